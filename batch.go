@@ -0,0 +1,69 @@
+package sqltracing
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/simplesurance/sqltracing/querynorm"
+)
+
+// Defines the names of the tracing tags that are recorded for queries that
+// consist of multiple statements.
+const (
+	DBStatementCountTagKey       = "db.statement.count"
+	DBStatementMixedDDLDMLTagKey = "db.statement.mixed_ddl_dml"
+	DBStatementIndexTagKey       = "db.statement.index"
+)
+
+// BatchStatementEventName is the name of the span events that describe the
+// statements of a query with multiple statements.
+const BatchStatementEventName = "db.batch.statement"
+
+// tagBatch records information about the statements of a query with
+// multiple statements on span.
+// The operation and tables of each statement are recorded as
+// BatchStatementEventName event, if the span implements SpanEventer.
+// Otherwise they are recorded as "db.statement.<index>.operation" and
+// "db.statement.<index>.table" tags.
+func tagBatch(span Span, batch []*querynorm.Analysis) {
+	tags := map[string]string{
+		DBStatementCountTagKey: strconv.Itoa(len(batch)),
+	}
+
+	eventer, hasEvents := span.(SpanEventer)
+	var hasDDL, hasDML bool
+
+	for i, stmt := range batch {
+		hasDDL = hasDDL || querynorm.IsDDL(stmt.Operation)
+		hasDML = hasDML || querynorm.IsDML(stmt.Operation)
+
+		tables := strings.Join(stmt.Tables, ",")
+
+		if hasEvents {
+			attrs := map[string]string{
+				DBStatementIndexTagKey: strconv.Itoa(i),
+				DBOperationTagKey:      stmt.Operation,
+			}
+
+			if tables != "" {
+				attrs[DBSQLTableTagKey] = tables
+			}
+
+			eventer.AddEvent(BatchStatementEventName, attrs)
+
+			continue
+		}
+
+		prefix := "db.statement." + strconv.Itoa(i) + "."
+		tags[prefix+"operation"] = stmt.Operation
+		if tables != "" {
+			tags[prefix+"table"] = tables
+		}
+	}
+
+	if hasDDL && hasDML {
+		tags[DBStatementMixedDDLDMLTagKey] = "true"
+	}
+
+	span.SetTags(tags)
+}
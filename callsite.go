@@ -0,0 +1,179 @@
+package sqltracing
+
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Defines the names of the tracing tags that describe the code location that
+// issued a database operation.
+const (
+	CodeFunctionTagKey = "code.function"
+	CodeFilepathTagKey = "code.filepath"
+	CodeLinenoTagKey   = "code.lineno"
+)
+
+// callSiteMaxDepth is the maximum number of stack frames that are inspected
+// to find the call site.
+const callSiteMaxDepth = 64
+
+// defaultCallSiteSkipPkgs are the packages whose functions are never reported
+// as call site.
+var defaultCallSiteSkipPkgs = []string{
+	"runtime",
+	"database/sql",
+	"github.com/simplesurance/sqlmw",
+	"github.com/simplesurance/sqltracing",
+}
+
+// callSite is the location of a function call.
+type callSite struct {
+	function string
+	file     string
+	line     int
+}
+
+// callSiteResolver finds the first function in the stack that is not part of
+// one of the skipped packages.
+// Resolving program counters to frames is expensive, therefore the result is
+// cached per program counter.
+type callSiteResolver struct {
+	skipPkgs []string
+
+	mu    sync.RWMutex
+	cache map[uintptr]*pcInfo
+}
+
+// pcInfo is the cached information about a program counter.
+// Because of inlining a program counter can resolve to multiple frames.
+type pcInfo struct {
+	frames []callSite
+	// skip is true if all frames of the program counter belong to
+	// skipped packages.
+	skip bool
+}
+
+type callSiteCtxKey struct{}
+
+func newCallSiteResolver(skipPkgs []string) *callSiteResolver {
+	pkgs := make([]string, 0, len(defaultCallSiteSkipPkgs)+len(skipPkgs))
+	pkgs = append(pkgs, defaultCallSiteSkipPkgs...)
+	pkgs = append(pkgs, skipPkgs...)
+
+	return &callSiteResolver{
+		skipPkgs: pkgs,
+		cache:    map[uintptr]*pcInfo{},
+	}
+}
+
+// resolve returns the call site of the current goroutine.
+// If no frame outside of the skipped packages is found, false is returned.
+func (r *callSiteResolver) resolve() (callSite, bool) {
+	var pcs [callSiteMaxDepth]uintptr
+
+	// skip runtime.Callers and resolve
+	n := runtime.Callers(2, pcs[:])
+
+	for _, pc := range pcs[:n] {
+		info := r.pcInfo(pc)
+		if info.skip {
+			continue
+		}
+
+		for _, frame := range info.frames {
+			if !r.isSkipped(frame.function) {
+				return frame, true
+			}
+		}
+	}
+
+	return callSite{}, false
+}
+
+// forOp returns the call site of an operation.
+// The stack is only walked for operations that are issued by the
+// application. Operations on the rows, statements and transactions that
+// they return inherit their call site via ctx, the returned context
+// contains the call site.
+func (r *callSiteResolver) forOp(ctx context.Context, op SQLOp) (context.Context, *callSite) {
+	if !op.isIssuedByApp() {
+		site, _ := ctx.Value(callSiteCtxKey{}).(*callSite)
+		return ctx, site
+	}
+
+	site, ok := r.resolve()
+	if !ok {
+		return ctx, nil
+	}
+
+	return context.WithValue(ctx, callSiteCtxKey{}, &site), &site
+}
+
+func (r *callSiteResolver) pcInfo(pc uintptr) *pcInfo {
+	r.mu.RLock()
+	info, exist := r.cache[pc]
+	r.mu.RUnlock()
+
+	if exist {
+		return info
+	}
+
+	info = &pcInfo{skip: true}
+
+	frames := runtime.CallersFrames([]uintptr{pc})
+	for {
+		frame, more := frames.Next()
+
+		info.frames = append(info.frames, callSite{
+			function: frame.Function,
+			file:     frame.File,
+			line:     frame.Line,
+		})
+
+		if !r.isSkipped(frame.Function) {
+			info.skip = false
+		}
+
+		if !more {
+			break
+		}
+	}
+
+	r.mu.Lock()
+	r.cache[pc] = info
+	r.mu.Unlock()
+
+	return info
+}
+
+// isSkipped returns true if the function belongs to one of the skipped
+// packages or one of their subpackages.
+func (r *callSiteResolver) isSkipped(function string) bool {
+	for _, pkg := range r.skipPkgs {
+		if !strings.HasPrefix(function, pkg) {
+			continue
+		}
+
+		if len(function) == len(pkg) {
+			return true
+		}
+
+		switch function[len(pkg)] {
+		case '.', '/':
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *callSite) tags() map[string]string {
+	return map[string]string{
+		CodeFunctionTagKey: c.function,
+		CodeFilepathTagKey: c.file,
+		CodeLinenoTagKey:   strconv.Itoa(c.line),
+	}
+}
@@ -0,0 +1,397 @@
+package sqltracing
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strconv"
+	"sync/atomic"
+)
+
+// Defines the names of the tracing tags that are recorded for connections.
+// DBConnOpsTagKey contains the number of operations that were run on the
+// connection, it is set on the OpSQLConnLifetime span.
+// DBConnInvalidTagKey and DBConnInvalidReasonTagKey are set on the
+// OpSQLConnClose span of connections that are discarded because they are
+// invalid, it is also recorded when WithConnLifetimeSpans was not passed.
+const (
+	DBConnOpsTagKey           = "db.conn.ops"
+	DBConnInvalidTagKey       = "db.conn.invalid"
+	DBConnInvalidReasonTagKey = "db.conn.invalid_reason"
+)
+
+// ConnInvalidEventName is the name of the span event that is recorded on
+// the OpSQLConnLifetime span when the connection is reported as invalid.
+// Without lifetime spans it is recorded on the OpSQLConnClose span.
+const ConnInvalidEventName = "db.conn.invalid"
+
+// Defines the values of the DBConnInvalidReasonTagKey tag.
+// ConnInvalidReasonIsValid is used when the IsValid method of the
+// connection returned false, ConnInvalidReasonResetSession when
+// ResetSession returned driver.ErrBadConn.
+const (
+	ConnInvalidReasonIsValid      = "is_valid"
+	ConnInvalidReasonResetSession = "reset_session"
+)
+
+// tracedConn wraps the connections created by ConnectorConnect.
+// It keeps per-connection state that the Interceptor can not derive from
+// the arguments of the sqlmw.Interceptor methods.
+//
+// All optional driver interfaces that are forwarded by sqlmw are
+// implemented, the same fallbacks as in sqlmw are used when the wrapped
+// connection does not support them. driver.ErrSkip is returned by the exec
+// and query methods when the wrapped connection does not support them,
+// outerConn checks this via supportsExec and supportsQuery before the
+// operation is passed to sqlmw.
+type tracedConn struct {
+	driver.Conn
+
+	// id identifies the connection, it is unique per process.
+	id uint64
+
+	// tx is the transaction that is currently active on the connection,
+	// database/sql ensures that a connection is not used concurrently.
+	tx *txStats
+
+	// tags are set on all spans of operations on the connection, they
+	// are returned by the OnConnectFunc.
+	tags map[string]string
+
+	icp *Interceptor
+
+	// ops is the number of operations that were run on the connection.
+	ops uint64
+
+	// invalidReason is set when the connection was reported as invalid
+	// by IsValid or ResetSession.
+	invalidReason string
+
+	// lifetimeSpan is the OpSQLConnLifetime span, it is nil if
+	// WithConnLifetimeSpans was not passed. lifetimeCtx contains the
+	// span.
+	lifetimeSpan Span
+	lifetimeCtx  context.Context
+}
+
+type txStats struct {
+	statements int64
+}
+
+// Compile time validation that our types implement the expected interfaces
+var (
+	_ driver.Conn               = &tracedConn{}
+	_ driver.ConnBeginTx        = &tracedConn{}
+	_ driver.ConnPrepareContext = &tracedConn{}
+	_ driver.Execer             = &tracedConn{}
+	_ driver.ExecerContext      = &tracedConn{}
+	_ driver.Pinger             = &tracedConn{}
+	_ driver.Queryer            = &tracedConn{}
+	_ driver.QueryerContext     = &tracedConn{}
+	_ driver.SessionResetter    = &tracedConn{}
+	_ driver.NamedValueChecker  = &tracedConn{}
+	_ driver.Validator          = &tracedConn{}
+)
+
+// lastConnID is the id of the last created tracedConn.
+var lastConnID uint64
+
+func newTracedConn(conn driver.Conn, icp *Interceptor) *tracedConn {
+	return &tracedConn{
+		Conn: conn,
+		id:   atomic.AddUint64(&lastConnID, 1),
+		icp:  icp,
+	}
+}
+
+type connRefCtxKey struct{}
+
+// connRef is passed via the context to the methods of tracedConn, they store
+// a reference to the connection in it.
+// This allows the Interceptor to find out on which connection an operation
+// was run.
+type connRef struct {
+	conn *tracedConn
+}
+
+func withConnRef(ctx context.Context) (context.Context, *connRef) {
+	ref := connRef{}
+	return context.WithValue(ctx, connRefCtxKey{}, &ref), &ref
+}
+
+func (c *tracedConn) setRef(ctx context.Context) {
+	c.countOp()
+	c.storeInRef(ctx)
+	setActiveOpConn(ctx, c)
+}
+
+// storeInRef stores c in the connRef in ctx, if it exists.
+func (c *tracedConn) storeInRef(ctx context.Context) {
+	if ref, ok := ctx.Value(connRefCtxKey{}).(*connRef); ok {
+		ref.conn = c
+	}
+}
+
+// connFromContext returns the connection that was stored in the connRef in
+// ctx. If it does not exist, nil is returned.
+func connFromContext(ctx context.Context) *tracedConn {
+	if ref, ok := ctx.Value(connRefCtxKey{}).(*connRef); ok {
+		return ref.conn
+	}
+
+	return nil
+}
+
+// tagSpan sets the tags of the connection on span.
+// It is safe to be called on a nil tracedConn.
+func (c *tracedConn) tagSpan(span Span) {
+	if c == nil || len(c.tags) == 0 {
+		return
+	}
+
+	span.SetTags(c.tags)
+}
+
+// countOp increments the number of operations that were run on the
+// connection.
+// It is safe to be called on a nil tracedConn.
+func (c *tracedConn) countOp() {
+	if c == nil {
+		return
+	}
+
+	atomic.AddUint64(&c.ops, 1)
+}
+
+// countStatement increments the statement counter of the transaction that
+// is active on the connection.
+// It is safe to be called on a nil tracedConn.
+func (c *tracedConn) countStatement() {
+	if c == nil || c.tx == nil {
+		return
+	}
+
+	atomic.AddInt64(&c.tx.statements, 1)
+}
+
+// supportsExec returns false if the wrapped connection implements neither
+// driver.ExecerContext nor driver.Execer.
+// It returns true when called on a nil tracedConn.
+func (c *tracedConn) supportsExec() bool {
+	if c == nil {
+		return true
+	}
+
+	_, hasExecerCtx := c.Conn.(driver.ExecerContext)
+	_, hasExecer := c.Conn.(driver.Execer)
+
+	return hasExecerCtx || hasExecer
+}
+
+// supportsQuery returns false if the wrapped connection implements neither
+// driver.QueryerContext nor driver.Queryer.
+// It returns true when called on a nil tracedConn.
+func (c *tracedConn) supportsQuery() bool {
+	if c == nil {
+		return true
+	}
+
+	_, hasQueryerCtx := c.Conn.(driver.QueryerContext)
+	_, hasQueryer := c.Conn.(driver.Queryer)
+
+	return hasQueryerCtx || hasQueryer
+}
+
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.setRef(ctx)
+
+	if connBeginTx, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return connBeginTx.BeginTx(ctx, opts)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+		return c.Conn.Begin()
+	}
+}
+
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	c.setRef(ctx)
+
+	if connPrepareCtx, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return connPrepareCtx.PrepareContext(ctx, query)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+		return c.Conn.Prepare(query)
+	}
+}
+
+func (c *tracedConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	if execer, ok := c.Conn.(driver.Execer); ok {
+		return execer.Exec(query, args)
+	}
+
+	return nil, driver.ErrSkip
+}
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.setRef(ctx)
+
+	if execerCtx, ok := c.Conn.(driver.ExecerContext); ok {
+		return execerCtx.ExecContext(ctx, query, args)
+	}
+
+	dargs, err := namedValueToValue(args)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+		return c.Exec(query, dargs)
+	}
+}
+
+func (c *tracedConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	if queryer, ok := c.Conn.(driver.Queryer); ok {
+		return queryer.Query(query, args)
+	}
+
+	return nil, driver.ErrSkip
+}
+
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.setRef(ctx)
+
+	if queryerCtx, ok := c.Conn.(driver.QueryerContext); ok {
+		return queryerCtx.QueryContext(ctx, query, args)
+	}
+
+	dargs, err := namedValueToValue(args)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+		return c.Query(query, dargs)
+	}
+}
+
+func (c *tracedConn) Ping(ctx context.Context) error {
+	c.setRef(ctx)
+
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
+func (c *tracedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return c.icp.connResetSession(ctx, c, resetter)
+	}
+
+	return nil
+}
+
+func (c *tracedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		if validator.IsValid() {
+			return true
+		}
+
+		c.markInvalid(ConnInvalidReasonIsValid)
+
+		return false
+	}
+
+	return true
+}
+
+func (c *tracedConn) Close() error {
+	return c.icp.connClose(c)
+}
+
+// startLifetimeSpan starts the OpSQLConnLifetime span as root span.
+// Connections outlive the operation that opened them, as child span it
+// would extend the trace of the operation.
+func (c *tracedConn) startLifetimeSpan(tracer Tracer) {
+	c.lifetimeSpan, c.lifetimeCtx = tracer.StartSpan(context.Background(), OpSQLConnLifetime.String())
+}
+
+// finishLifetimeSpan records the number of operations on the lifetime span
+// and finishes it.
+func (c *tracedConn) finishLifetimeSpan() {
+	if c.lifetimeSpan == nil {
+		return
+	}
+
+	c.tagSpan(c.lifetimeSpan)
+	c.lifetimeSpan.SetTag(DBConnOpsTagKey, strconv.FormatUint(atomic.LoadUint64(&c.ops), 10))
+	c.lifetimeSpan.Finish()
+
+	c.lifetimeSpan = nil
+}
+
+// markInvalid records that the connection is invalid and will be
+// discarded.
+// The ConnInvalidEventName event is recorded on the lifetime span, if it
+// exists. Otherwise it is recorded on the OpSQLConnClose span.
+func (c *tracedConn) markInvalid(reason string) {
+	c.invalidReason = reason
+
+	if c.lifetimeSpan != nil {
+		recordConnInvalid(c.lifetimeSpan, reason)
+	}
+}
+
+// recordConnInvalid records the ConnInvalidEventName event on span, if the
+// span implements SpanEventer. Otherwise it is tagged with
+// DBConnInvalidTagKey and DBConnInvalidReasonTagKey.
+func recordConnInvalid(span Span, reason string) {
+	if eventer, ok := span.(SpanEventer); ok {
+		eventer.AddEvent(ConnInvalidEventName, map[string]string{
+			DBConnInvalidReasonTagKey: reason,
+		})
+
+		return
+	}
+
+	span.SetTags(map[string]string{
+		DBConnInvalidTagKey:       "true",
+		DBConnInvalidReasonTagKey: reason,
+	})
+}
+
+func (c *tracedConn) CheckNamedValue(v *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(v)
+	}
+
+	// database/sql applies its default conversion when ErrSkip is returned
+	return driver.ErrSkip
+}
+
+// namedValueToValue is a helper function copied from the database/sql package
+func namedValueToValue(named []driver.NamedValue) ([]driver.Value, error) {
+	dargs := make([]driver.Value, len(named))
+	for n, param := range named {
+		if len(param.Name) > 0 {
+			return nil, errors.New("sql: driver does not support the use of Named Parameters")
+		}
+		dargs[n] = param.Value
+	}
+	return dargs, nil
+}
@@ -5,19 +5,29 @@ package sqltracing_test
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	opentracing_go "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/mocktracer"
 	"github.com/simplesurance/sqltracing"
+	"github.com/simplesurance/sqltracing/metrics/inprocess"
 	"github.com/simplesurance/sqltracing/tracing/opentracing"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func mustNewDBDriver(t *testing.T) (*mocktracer.MockTracer, string) {
+func mustNewDBDriver(t *testing.T, opts ...sqltracing.Opt) (*mocktracer.MockTracer, string) {
 	t.Helper()
 
 	driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
@@ -30,9 +40,10 @@ func mustNewDBDriver(t *testing.T) (*mocktracer.MockTracer, string) {
 			&nullDriver{con: &nullCon{}},
 			opentracing.NewTracer(
 				opentracing.WithTracer(
-					func() opentracing_go.Tracer { return mockTracer },
+					func() opentracing_go.Tracer { return newStrictTracer(mockTracer) },
 				),
 			),
+			opts...,
 		),
 	)
 
@@ -164,6 +175,172 @@ func TestExecContext(t *testing.T) {
 	assertHasSpan(t, mockTracer, sqltracing.OpSQLConnExec)
 }
 
+func TestExecResult(t *testing.T) {
+	mockTracer, driverName := mustNewDBDriver(t)
+	db := mustNewDB(t, driverName)
+
+	res, err := db.ExecContext(context.Background(), "")
+	require.NoError(t, err)
+
+	cnt, err := res.RowsAffected()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, cnt)
+
+	id, err := res.LastInsertId()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, id)
+
+	// no additional spans are recorded for the result
+	assert.Len(t, mockTracer.FinishedSpans(), 2)
+
+	span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+	assert.Nil(t, span.Tag(sqltracing.DBRowsAffectedTagKey))
+	assert.Nil(t, span.Tag(sqltracing.DBLastInsertIDTagKey))
+}
+
+func TestWithRowsAffected(t *testing.T) {
+	mockTracer, driverName := mustNewDBDriver(t, sqltracing.WithRowsAffected())
+	db := mustNewDB(t, driverName)
+
+	_, err := db.ExecContext(context.Background(), "")
+	require.NoError(t, err)
+
+	span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+	assert.Equal(t, "1", span.Tag(sqltracing.DBRowsAffectedTagKey))
+}
+
+func TestWithLastInsertID(t *testing.T) {
+	mockTracer, driverName := mustNewDBDriver(t, sqltracing.WithLastInsertID())
+	db := mustNewDB(t, driverName)
+
+	_, err := db.ExecContext(context.Background(), "")
+	require.NoError(t, err)
+
+	span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+	assert.Equal(t, "1", span.Tag(sqltracing.DBLastInsertIDTagKey))
+	assert.Nil(t, span.Tag(sqltracing.DBRowsAffectedTagKey))
+}
+
+func TestWithCallSite(t *testing.T) {
+	mockTracer, driverName := mustNewDBDriver(t, sqltracing.WithCallSite())
+	db := mustNewDB(t, driverName)
+
+	_, err := db.ExecContext(context.Background(), "")
+	require.NoError(t, err)
+
+	span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+
+	assert.Equal(t, "github.com/simplesurance/sqltracing_test.TestWithCallSite", span.Tag(sqltracing.CodeFunctionTagKey))
+	assert.True(t, strings.HasSuffix(span.Tag(sqltracing.CodeFilepathTagKey).(string), "driver_test.go"))
+	assert.NotEmpty(t, span.Tag(sqltracing.CodeLinenoTagKey))
+
+	rows, err := db.QueryContext(context.Background(), "")
+	require.NoError(t, err)
+
+	for rows.Next() {
+	}
+	require.NoError(t, rows.Close())
+
+	// rows operations inherit the call site of the query
+	querySpan := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnQuery.String())
+	require.NotNil(t, querySpan)
+	require.NotEmpty(t, querySpan.Tag(sqltracing.CodeLinenoTagKey))
+
+	for _, op := range []sqltracing.SQLOp{sqltracing.OpSQLRowsNext, sqltracing.OpSQLRowsClose} {
+		span := findFinishedSpan(t, mockTracer, op.String())
+		require.NotNil(t, span)
+		assert.Equal(t, querySpan.Tag(sqltracing.CodeLinenoTagKey), span.Tag(sqltracing.CodeLinenoTagKey), op)
+	}
+}
+
+func TestWithCallSiteSkipPkgs(t *testing.T) {
+	mockTracer, driverName := mustNewDBDriver(t, sqltracing.WithCallSite("github.com/simplesurance/sqltracing_test"))
+	db := mustNewDB(t, driverName)
+
+	_, err := db.ExecContext(context.Background(), "")
+	require.NoError(t, err)
+
+	span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+
+	assert.Equal(t, "testing.tRunner", span.Tag(sqltracing.CodeFunctionTagKey))
+}
+
+func TestQueryName(t *testing.T) {
+	t.Run("from-context", func(t *testing.T) {
+		mockTracer, driverName := mustNewDBDriver(t)
+		db := mustNewDB(t, driverName)
+
+		ctx := sqltracing.WithQueryName(context.Background(), "GetUserByID")
+		_, err := db.ExecContext(ctx, "-- name: ListUsers :many\nSELECT 1")
+		require.NoError(t, err)
+
+		span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+		require.NotNil(t, span)
+		assert.Equal(t, "GetUserByID", span.Tag(sqltracing.DBQueryNameTagKey))
+	})
+
+	t.Run("without-extraction", func(t *testing.T) {
+		mockTracer, driverName := mustNewDBDriver(t)
+		db := mustNewDB(t, driverName)
+
+		_, err := db.ExecContext(context.Background(), "-- name: ListUsers :many\nSELECT 1")
+		require.NoError(t, err)
+
+		span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+		require.NotNil(t, span)
+		assert.Nil(t, span.Tag(sqltracing.DBQueryNameTagKey))
+	})
+
+	t.Run("from-comments", func(t *testing.T) {
+		mockTracer, driverName := mustNewDBDriver(t, sqltracing.WithQueryNamesFromComments())
+		db := mustNewDB(t, driverName)
+
+		_, err := db.ExecContext(context.Background(), "-- name: ListUsers :many\nSELECT 1")
+		require.NoError(t, err)
+
+		span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+		require.NotNil(t, span)
+		assert.Equal(t, "ListUsers", span.Tag(sqltracing.DBQueryNameTagKey))
+
+		mockTracer.Reset()
+
+		stmt, err := db.PrepareContext(context.Background(), "SELECT 1 /* name=GetUser */")
+		require.NoError(t, err)
+
+		_, err = stmt.ExecContext(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, stmt.Close())
+
+		span = findFinishedSpan(t, mockTracer, sqltracing.OpSQLPrepare.String())
+		require.NotNil(t, span)
+		assert.Equal(t, "GetUser", span.Tag(sqltracing.DBQueryNameTagKey))
+
+		span = findFinishedSpan(t, mockTracer, sqltracing.OpSQLStmtExec.String())
+		require.NotNil(t, span)
+		assert.Equal(t, "GetUser", span.Tag(sqltracing.DBQueryNameTagKey))
+	})
+
+	t.Run("as-span-name", func(t *testing.T) {
+		mockTracer, driverName := mustNewDBDriver(t, sqltracing.WithQueryNameAsSpanName())
+		db := mustNewDB(t, driverName)
+
+		ctx := sqltracing.WithQueryName(context.Background(), "GetUserByID")
+		rows, err := db.QueryContext(ctx, "")
+		require.NoError(t, err)
+
+		rows.Next()
+		rows.Close()
+
+		assertHasNotSpan(t, mockTracer, sqltracing.OpSQLConnQuery)
+		assertIsParentSpan(t, mockTracer, "GetUserByID", sqltracing.OpSQLRowsNext.String())
+	})
+}
+
 func TestQuery(t *testing.T) {
 	mockTracer, driverName := mustNewDBDriver(t)
 	db := mustNewDB(t, driverName)
@@ -194,6 +371,47 @@ func TestQueryContext(t *testing.T) {
 	assertIsParentSpanOp(t, mockTracer, sqltracing.OpSQLConnQuery, sqltracing.OpSQLRowsClose)
 }
 
+func TestQueryColumns(t *testing.T) {
+	t.Run("count", func(t *testing.T) {
+		mockTracer, driverName := mustNewDBDriver(t)
+		db := mustNewDB(t, driverName)
+
+		rows, err := db.QueryContext(context.Background(), "")
+		require.NoError(t, err)
+
+		rows.Next()
+		rows.Close()
+
+		span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnQuery.String())
+		require.NotNil(t, span)
+
+		assert.Equal(t, "1", span.Tag(sqltracing.DBColumnsCountTagKey))
+		assert.Nil(t, span.Tag(sqltracing.DBColumnsNamesTagKey))
+	})
+
+	t.Run("names", func(t *testing.T) {
+		mockTracer, driverName := mustNewDBDriver(t, sqltracing.WithColumnNames())
+		db := mustNewDB(t, driverName)
+
+		stmt, err := db.PrepareContext(context.Background(), "")
+		require.NoError(t, err)
+
+		rows, err := stmt.QueryContext(context.Background())
+		require.NoError(t, err)
+
+		rows.Next()
+		rows.Close()
+		stmt.Close()
+
+		span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLStmtQuery.String())
+		require.NotNil(t, span)
+
+		assert.Equal(t, "1", span.Tag(sqltracing.DBColumnsCountTagKey))
+		assert.Equal(t, "1", span.Tag(sqltracing.DBColumnsNamesTagKey))
+		assert.Nil(t, span.Tag(sqltracing.DBColumnsTypesTagKey))
+	})
+}
+
 func TestTxBeginCommit(t *testing.T) {
 	mockTracer, driverName := mustNewDBDriver(t)
 	db := mustNewDB(t, driverName)
@@ -220,6 +438,100 @@ func TestTxBeginRollback(t *testing.T) {
 	assertIsParentSpanOp(t, mockTracer, sqltracing.OpSQLTxBegin, sqltracing.OpSQLTxRollback)
 }
 
+func TestTxTags(t *testing.T) {
+	mockTracer, driverName := mustNewDBDriver(t)
+	db := mustNewDB(t, driverName)
+
+	t.Run("commit", func(t *testing.T) {
+		mockTracer.Reset()
+
+		tx, err := db.BeginTx(context.Background(), &sql.TxOptions{
+			Isolation: sql.LevelSerializable,
+			ReadOnly:  true,
+		})
+		require.NoError(t, err)
+
+		_, err = tx.ExecContext(context.Background(), "")
+		require.NoError(t, err)
+
+		rows, err := tx.QueryContext(context.Background(), "")
+		require.NoError(t, err)
+		require.NoError(t, rows.Close())
+
+		require.NoError(t, tx.Commit())
+
+		span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLTxBegin.String())
+		require.NotNil(t, span)
+
+		assert.Equal(t, "serializable", span.Tag(sqltracing.DBTxIsolationTagKey))
+		assert.Equal(t, "true", span.Tag(sqltracing.DBTxReadOnlyTagKey))
+		assert.Equal(t, sqltracing.TxOutcomeCommit, span.Tag(sqltracing.DBTxOutcomeTagKey))
+		assert.Equal(t, "2", span.Tag(sqltracing.DBTxStatementsTagKey))
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		mockTracer.Reset()
+
+		tx, err := db.BeginTx(context.Background(), nil)
+		require.NoError(t, err)
+
+		require.NoError(t, tx.Rollback())
+
+		span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLTxBegin.String())
+		require.NotNil(t, span)
+
+		assert.Equal(t, "default", span.Tag(sqltracing.DBTxIsolationTagKey))
+		assert.Equal(t, "false", span.Tag(sqltracing.DBTxReadOnlyTagKey))
+		assert.Equal(t, sqltracing.TxOutcomeRollback, span.Tag(sqltracing.DBTxOutcomeTagKey))
+		assert.Equal(t, "0", span.Tag(sqltracing.DBTxStatementsTagKey))
+	})
+}
+
+func TestConnWithoutExecerQueryer(t *testing.T) {
+	driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+	mockTracer := mocktracer.New()
+
+	sql.Register(
+		driverName,
+		sqltracing.WrapDriver(
+			&nullDriver{con: &basicCon{}},
+			opentracing.NewTracer(
+				opentracing.WithTracer(
+					func() opentracing_go.Tracer { return newStrictTracer(mockTracer) },
+				),
+			),
+		),
+	)
+	db := mustNewDB(t, driverName)
+
+	tx, err := db.BeginTx(context.Background(), nil)
+	require.NoError(t, err)
+
+	_, err = tx.ExecContext(context.Background(), "UPDATE t SET a = 1")
+	require.NoError(t, err)
+
+	rows, err := tx.QueryContext(context.Background(), "SELECT 1")
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+
+	require.NoError(t, tx.Commit())
+
+	// database/sql falls back to prepared statements
+	assertHasNotSpan(t, mockTracer, sqltracing.OpSQLConnExec)
+	assertHasNotSpan(t, mockTracer, sqltracing.OpSQLConnQuery)
+	assertHasSpan(t, mockTracer, sqltracing.OpSQLPrepare)
+	assertHasSpan(t, mockTracer, sqltracing.OpSQLStmtExec)
+	assertHasSpan(t, mockTracer, sqltracing.OpSQLStmtQuery)
+
+	for _, span := range mockTracer.FinishedSpans() {
+		assert.Nilf(t, span.Tag("error"), "span %q is tagged as error", span.OperationName)
+	}
+
+	span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLTxBegin.String())
+	require.NotNil(t, span)
+	assert.Equal(t, "2", span.Tag(sqltracing.DBTxStatementsTagKey))
+}
+
 func TestPrepareContext(t *testing.T) {
 	mockTracer, driverName := mustNewDBDriver(t)
 	db := mustNewDB(t, driverName)
@@ -286,7 +598,7 @@ func TestWithOpsExcluded(t *testing.T) {
 			&nullDriver{con: &nullCon{}},
 			opentracing.NewTracer(
 				opentracing.WithTracer(
-					func() opentracing_go.Tracer { return mockTracer },
+					func() opentracing_go.Tracer { return newStrictTracer(mockTracer) },
 				),
 			),
 			sqltracing.WithOpsExcluded(
@@ -309,6 +621,1549 @@ func TestWithOpsExcluded(t *testing.T) {
 	assertHasNotSpan(t, mockTracer, sqltracing.OpSQLConnQuery)
 }
 
+func TestWithMetrics(t *testing.T) {
+	driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+	metrics := inprocess.New()
+	con := nullCon{}
+
+	sql.Register(
+		driverName,
+		sqltracing.WrapDriver(
+			&nullDriver{con: &con},
+			opentracing.NewTracer(opentracing.WithoutTracingOrphans()),
+			sqltracing.WithMetrics(metrics),
+			sqltracing.WithOpsExcluded(sqltracing.OpSQLRowsNext),
+		),
+	)
+	db := mustNewDB(t, driverName)
+
+	rows, err := db.QueryContext(context.Background(), "")
+	require.NoError(t, err)
+
+	rows.Next()
+	rows.Close()
+
+	con.err = context.DeadlineExceeded
+	_, err = db.ExecContext(context.Background(), "")
+	require.Error(t, err)
+
+	snapshot := metrics.Snapshot()
+
+	series := map[inprocess.Labels]uint64{}
+	for _, s := range snapshot.Series {
+		series[s.Labels] = s.Count
+	}
+
+	assert.Equal(t, map[inprocess.Labels]uint64{
+		{Op: sqltracing.OpSQLConnect, Outcome: sqltracing.OutcomeSuccess}:   1,
+		{Op: sqltracing.OpSQLConnQuery, Outcome: sqltracing.OutcomeSuccess}: 1,
+		{Op: sqltracing.OpSQLRowsClose, Outcome: sqltracing.OutcomeSuccess}: 1,
+		{
+			Op:         sqltracing.OpSQLConnExec,
+			Outcome:    sqltracing.OutcomeError,
+			ErrorClass: sqltracing.ErrorClassTimeout,
+		}: 1,
+	}, series)
+
+	for op, cnt := range snapshot.InFlight {
+		assert.Zerof(t, cnt, "in-flight counter of %s is not 0", op)
+	}
+}
+
+func TestWithMetricsFingerprint(t *testing.T) {
+	driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+	metrics := inprocess.New(inprocess.WithFingerprintLabel())
+
+	sql.Register(
+		driverName,
+		sqltracing.WrapDriver(
+			&nullDriver{con: &nullCon{}},
+			opentracing.NewTracer(opentracing.WithoutTracingOrphans()),
+			sqltracing.WithMetrics(metrics),
+			sqltracing.WithMetricsFingerprint(),
+		),
+	)
+	db := mustNewDB(t, driverName)
+
+	for _, id := range []int{1, 2} {
+		_, err := db.ExecContext(context.Background(), fmt.Sprintf("UPDATE t SET a = %d", id))
+		require.NoError(t, err)
+	}
+
+	_, err := db.ExecContext(context.Background(), "DELETE FROM t")
+	require.NoError(t, err)
+
+	var counts []uint64
+	for _, s := range metrics.Snapshot().Series {
+		if s.Op != sqltracing.OpSQLConnExec {
+			continue
+		}
+
+		assert.Len(t, s.Fingerprint, 16)
+		counts = append(counts, s.Count)
+	}
+
+	// both UPDATE statements have the same fingerprint
+	assert.ElementsMatch(t, []uint64{2, 1}, counts)
+}
+
+func TestPoolStatsCollector(t *testing.T) {
+	metrics := inprocess.New()
+	collector := sqltracing.NewPoolStatsCollector(
+		sqltracing.WithPoolName("main"),
+		sqltracing.WithPoolMetrics(metrics),
+	)
+
+	mockTracer, driverName := mustNewDBDriver(t, sqltracing.WithPoolStatsCollector(collector))
+	db := mustNewDB(t, driverName)
+
+	_, err := db.ExecContext(context.Background(), "")
+	require.NoError(t, err)
+
+	span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+	assert.Nil(t, span.Tag(sqltracing.DBPoolWaitDurationTagKey))
+
+	collector.Collect(db)
+
+	mockTracer.Reset()
+
+	_, err = db.ExecContext(context.Background(), "")
+	require.NoError(t, err)
+
+	span = findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+	assert.Equal(t, "0s", span.Tag(sqltracing.DBPoolWaitDurationTagKey))
+
+	// operations that do not take a connection are not tagged
+	rows, err := db.QueryContext(context.Background(), "")
+	require.NoError(t, err)
+	for rows.Next() {
+	}
+	require.NoError(t, rows.Close())
+
+	span = findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnQuery.String())
+	require.NotNil(t, span)
+	assert.Equal(t, "0s", span.Tag(sqltracing.DBPoolWaitDurationTagKey))
+
+	for _, op := range []sqltracing.SQLOp{sqltracing.OpSQLRowsNext, sqltracing.OpSQLRowsClose} {
+		span = findFinishedSpan(t, mockTracer, op.String())
+		require.NotNil(t, span)
+		assert.Nil(t, span.Tag(sqltracing.DBPoolWaitDurationTagKey))
+	}
+
+	pools := metrics.Snapshot().Pools
+	require.Len(t, pools, 1)
+	assert.Equal(t, "main", pools[0].Name)
+	assert.Equal(t, 1, pools[0].OpenConnections)
+	assert.Equal(t, 1, pools[0].Idle)
+}
+
+func TestPoolStatsCollectorInvalidInterval(t *testing.T) {
+	_, driverName := mustNewDBDriver(t)
+	db := mustNewDB(t, driverName)
+
+	collector := sqltracing.NewPoolStatsCollector(sqltracing.WithPoolStatsInterval(0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.NotPanics(t, func() { collector.Run(ctx, db) })
+}
+
+func TestRegistry(t *testing.T) {
+	driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+	mockTracer := mocktracer.New()
+	registry := sqltracing.NewRegistry()
+
+	sql.Register(
+		driverName,
+		sqltracing.WrapDriver(
+			&nullDriver{con: &nullCon{}},
+			opentracing.NewTracer(
+				opentracing.WithTracer(
+					func() opentracing_go.Tracer { return newStrictTracer(mockTracer) },
+				),
+				opentracing.WithSpanIDs(func(sc opentracing_go.SpanContext) (string, string) {
+					msc := sc.(mocktracer.MockSpanContext)
+					return fmt.Sprint(msc.TraceID), fmt.Sprint(msc.SpanID)
+				}),
+			),
+			sqltracing.WithRegistry(registry),
+		),
+	)
+	db := mustNewDB(t, driverName)
+
+	rows, err := db.QueryContext(context.Background(), "SELECT 1")
+	require.NoError(t, err)
+
+	active := registry.Active()
+	require.Len(t, active, 1)
+
+	assert.Equal(t, sqltracing.OpSQLConnQuery, active[0].Op)
+	assert.Equal(t, "SELECT 1", active[0].Query)
+	assert.NotZero(t, active[0].ConnID)
+	assert.NotEmpty(t, active[0].TraceID)
+	assert.NotEmpty(t, active[0].SpanID)
+
+	t.Run("json", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		registry.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?format=json", nil))
+
+		var ops []*sqltracing.ActiveOp
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ops))
+		require.Len(t, ops, 1)
+		assert.Equal(t, active[0].ID, ops[0].ID)
+	})
+
+	t.Run("html", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		registry.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Contains(t, rec.Body.String(), "<td>SELECT 1</td>")
+	})
+
+	require.NoError(t, rows.Close())
+	assert.Empty(t, registry.Active())
+}
+
+func TestRegistryConcurrentOps(t *testing.T) {
+	registry := sqltracing.NewRegistry()
+	_, driverName := mustNewDBDriver(t, sqltracing.WithRegistry(registry))
+	db := mustNewDB(t, driverName)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				rows, err := db.QueryContext(context.Background(), "SELECT 1")
+				if !assert.NoError(t, err) {
+					return
+				}
+
+				for rows.Next() {
+				}
+
+				assert.NoError(t, rows.Close())
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Empty(t, registry.Active())
+}
+
+func TestQueryStats(t *testing.T) {
+	driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+	stats := sqltracing.NewQueryStats(sqltracing.WithMaxQueries(2))
+	con := nullCon{}
+
+	sql.Register(
+		driverName,
+		sqltracing.WrapDriver(
+			&nullDriver{con: &con},
+			opentracing.NewTracer(opentracing.WithoutTracingOrphans()),
+			sqltracing.WithQueryStats(stats),
+		),
+	)
+	db := mustNewDB(t, driverName)
+
+	_, err := db.ExecContext(context.Background(), "UPDATE t SET a = 1")
+	require.NoError(t, err)
+
+	_, err = db.ExecContext(context.Background(), "update t\n  SET a = 2")
+	require.NoError(t, err)
+
+	stmt, err := db.PrepareContext(context.Background(), "UPDATE t SET a = $1")
+	require.NoError(t, err)
+
+	_, err = stmt.ExecContext(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stmt.Close())
+
+	rows, err := db.QueryContext(context.Background(), "SELECT 1")
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+
+	con.err = errors.New("error")
+	_, err = db.ExecContext(context.Background(), "SELECT 1")
+	require.Error(t, err)
+
+	snapshot := stats.Snapshot()
+	require.Len(t, snapshot, 2)
+
+	byQuery := map[string]*sqltracing.QueryStat{}
+	for _, stat := range snapshot {
+		byQuery[stat.Query] = stat
+	}
+
+	update := byQuery["UPDATE t SET a = ?"]
+	require.NotNil(t, update)
+	assert.Equal(t, uint64(3), update.Calls)
+	assert.Equal(t, uint64(0), update.Errors)
+	assert.Equal(t, int64(3), update.Rows)
+	assert.LessOrEqual(t, int64(update.MinTime), int64(update.P50Time))
+	assert.LessOrEqual(t, int64(update.MinTime), int64(update.MeanTime))
+	assert.GreaterOrEqual(t, int64(update.MaxTime), int64(update.MeanTime))
+
+	sel := byQuery["SELECT ?"]
+	require.NotNil(t, sel)
+	assert.Equal(t, uint64(2), sel.Calls)
+	assert.Equal(t, uint64(1), sel.Errors)
+
+	t.Run("eviction", func(t *testing.T) {
+		con.err = nil
+
+		_, err = db.ExecContext(context.Background(), "DELETE FROM t")
+		require.NoError(t, err)
+
+		snapshot := stats.Snapshot()
+		require.Len(t, snapshot, 2)
+
+		queries := []string{snapshot[0].Query, snapshot[1].Query}
+		assert.Contains(t, queries, "DELETE FROM t")
+		assert.Contains(t, queries, "UPDATE t SET a = ?")
+	})
+
+	t.Run("grace-period", func(t *testing.T) {
+		stats := sqltracing.NewQueryStats(
+			sqltracing.WithMaxQueries(2),
+			sqltracing.WithEvictionGracePeriod(50*time.Millisecond),
+		)
+		driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+
+		sql.Register(
+			driverName,
+			sqltracing.WrapDriver(
+				&nullDriver{con: &nullCon{}},
+				opentracing.NewTracer(opentracing.WithoutTracingOrphans()),
+				sqltracing.WithQueryStats(stats),
+			),
+		)
+		db := mustNewDB(t, driverName)
+
+		exec := func(query string) {
+			_, err := db.ExecContext(context.Background(), query)
+			require.NoError(t, err)
+		}
+
+		queries := func() []string {
+			var result []string
+			for _, stat := range stats.Snapshot() {
+				result = append(result, stat.Query)
+			}
+
+			return result
+		}
+
+		exec("UPDATE a SET x = 1")
+		exec("UPDATE a SET x = 1")
+		exec("UPDATE b SET x = 1")
+		time.Sleep(60 * time.Millisecond)
+
+		exec("UPDATE c SET x = 1")
+		assert.ElementsMatch(t, []string{"UPDATE a SET x = ?", "UPDATE c SET x = ?"}, queries())
+
+		// the new query is not replaced by the next new query
+		exec("UPDATE d SET x = 1")
+		assert.ElementsMatch(t, []string{"UPDATE c SET x = ?", "UPDATE d SET x = ?"}, queries())
+	})
+
+	t.Run("invalid-max-queries", func(t *testing.T) {
+		stats := sqltracing.NewQueryStats(sqltracing.WithMaxQueries(0))
+		driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+
+		sql.Register(
+			driverName,
+			sqltracing.WrapDriver(
+				&nullDriver{con: &nullCon{}},
+				opentracing.NewTracer(opentracing.WithoutTracingOrphans()),
+				sqltracing.WithQueryStats(stats),
+			),
+		)
+		db := mustNewDB(t, driverName)
+
+		for _, query := range []string{"UPDATE a SET x = 1", "UPDATE b SET x = 1"} {
+			_, err := db.ExecContext(context.Background(), query)
+			require.NoError(t, err)
+		}
+
+		assert.Len(t, stats.Snapshot(), 1)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		stats.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?format=json&sort=calls", nil))
+
+		var result []*sqltracing.QueryStat
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+		require.Len(t, result, 2)
+		assert.Equal(t, "UPDATE t SET a = ?", result[0].Query)
+	})
+
+	t.Run("html", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		stats.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Contains(t, rec.Body.String(), "<td>DELETE FROM t</td>")
+	})
+
+	stats.Reset()
+	assert.Empty(t, stats.Snapshot())
+}
+
+func TestWithQueryFingerprint(t *testing.T) {
+	mockTracer, driverName := mustNewDBDriver(t, sqltracing.WithQueryFingerprint())
+	db := mustNewDB(t, driverName)
+
+	_, err := db.ExecContext(context.Background(), "DELETE FROM t WHERE id IN (1, 2, 3)")
+	require.NoError(t, err)
+
+	span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+	fp1 := span.Tag(sqltracing.DBQueryFingerprintTagKey)
+	assert.NotEmpty(t, fp1)
+
+	mockTracer.Reset()
+
+	_, err = db.ExecContext(context.Background(), "delete from t where id in ($1)", 4)
+	require.NoError(t, err)
+
+	span = findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+	assert.Equal(t, fp1, span.Tag(sqltracing.DBQueryFingerprintTagKey))
+}
+
+func TestWithOperationAndTables(t *testing.T) {
+	mockTracer, driverName := mustNewDBDriver(t, sqltracing.WithOperationAndTables())
+	db := mustNewDB(t, driverName)
+
+	_, err := db.ExecContext(context.Background(), "UPDATE orders SET a = 1 FROM public.users u WHERE u.id = orders.uid")
+	require.NoError(t, err)
+
+	span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+	assert.Equal(t, "UPDATE", span.Tag(sqltracing.DBOperationTagKey))
+	assert.Equal(t, "orders,public.users", span.Tag(sqltracing.DBSQLTableTagKey))
+
+	stmt, err := db.PrepareContext(context.Background(), "SELECT 1")
+	require.NoError(t, err)
+	require.NoError(t, stmt.Close())
+
+	span = findFinishedSpan(t, mockTracer, sqltracing.OpSQLPrepare.String())
+	require.NotNil(t, span)
+	assert.Equal(t, "SELECT", span.Tag(sqltracing.DBOperationTagKey))
+	assert.Nil(t, span.Tag(sqltracing.DBSQLTableTagKey))
+}
+
+func TestNPlusOneDetector(t *testing.T) {
+	var events []*sqltracing.NPlusOneEvent
+
+	detector := sqltracing.NewNPlusOneDetector(
+		sqltracing.WithNPlusOneThreshold(3),
+		sqltracing.WithNPlusOneCallback(func(_ context.Context, ev *sqltracing.NPlusOneEvent) {
+			events = append(events, ev)
+		}),
+	)
+
+	mockTracer, driverName := mustNewDBDriver(t, sqltracing.WithNPlusOneDetector(detector))
+	db := mustNewDB(t, driverName)
+
+	parent := mockTracer.StartSpan("handler")
+	ctx := opentracing_go.ContextWithSpan(context.Background(), parent)
+
+	for i := 0; i < 4; i++ {
+		_, err := db.ExecContext(ctx, fmt.Sprintf("SELECT * FROM users WHERE id = %d", i))
+		require.NoError(t, err)
+	}
+
+	otherParent := mockTracer.StartSpan("handler")
+	_, err := db.ExecContext(opentracing_go.ContextWithSpan(context.Background(), otherParent), "SELECT * FROM users WHERE id = 1")
+	require.NoError(t, err)
+
+	var counts []interface{}
+	for _, span := range mockTracer.FinishedSpans() {
+		if span.OperationName == sqltracing.OpSQLConnExec.String() {
+			counts = append(counts, span.Tag(sqltracing.DBNPlusOneCountTagKey))
+		}
+	}
+
+	assert.Equal(t, []interface{}{nil, nil, "3", "4", nil}, counts)
+
+	require.Len(t, events, 1)
+	assert.Equal(t, "SELECT * FROM users WHERE id = ?", events[0].Query)
+	assert.Equal(t, 3, events[0].Count)
+}
+
+func TestNPlusOneDetectorPreparedStmts(t *testing.T) {
+	// database/sql prepares a statement per execution when the driver
+	// does not support executing queries directly
+	var events []*sqltracing.NPlusOneEvent
+
+	detector := sqltracing.NewNPlusOneDetector(
+		sqltracing.WithNPlusOneThreshold(3),
+		sqltracing.WithNPlusOneIdleTimeout(-1),
+		sqltracing.WithNPlusOneCallback(func(_ context.Context, ev *sqltracing.NPlusOneEvent) {
+			events = append(events, ev)
+		}),
+	)
+
+	mockTracer, driverName := mustNewDBDriver(t, sqltracing.WithNPlusOneDetector(detector))
+	db := mustNewDB(t, driverName)
+
+	parent := mockTracer.StartSpan("handler")
+	ctx := opentracing_go.ContextWithSpan(context.Background(), parent)
+
+	for i := 0; i < 4; i++ {
+		stmt, err := db.PrepareContext(ctx, fmt.Sprintf("SELECT * FROM users WHERE id = %d", i))
+		require.NoError(t, err)
+
+		_, err = stmt.ExecContext(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stmt.Close())
+	}
+
+	var counts []interface{}
+	for _, span := range mockTracer.FinishedSpans() {
+		if span.OperationName == sqltracing.OpSQLStmtExec.String() {
+			counts = append(counts, span.Tag(sqltracing.DBNPlusOneCountTagKey))
+		}
+	}
+
+	assert.Equal(t, []interface{}{nil, nil, "3", "4"}, counts)
+	require.Len(t, events, 1)
+}
+
+func TestWithMaxStatementLength(t *testing.T) {
+	// cuts the statement in the middle of a multi-byte character
+	const maxLen = 42
+
+	mockTracer, driverName := mustNewDBDriver(t, sqltracing.WithMaxStatementLength(maxLen))
+	db := mustNewDB(t, driverName)
+
+	_, err := db.ExecContext(context.Background(), "SELECT 1")
+	require.NoError(t, err)
+
+	span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+	assert.Equal(t, "SELECT 1", span.Tag(sqltracing.DBStatementTagKey))
+	assert.Nil(t, span.Tag(sqltracing.DBStatementLengthTagKey))
+	assert.Nil(t, span.Tag(sqltracing.DBStatementHashTagKey))
+
+	mockTracer.Reset()
+
+	query := "INSERT INTO t (a) VALUES ('äöü')" + strings.Repeat(", ('äöü')", 100)
+	_, err = db.ExecContext(context.Background(), query)
+	require.NoError(t, err)
+
+	span = findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+
+	stmt := span.Tag(sqltracing.DBStatementTagKey).(string)
+	assert.LessOrEqual(t, len(stmt), maxLen)
+	assert.True(t, utf8.ValidString(stmt))
+	assert.True(t, strings.HasSuffix(stmt, sqltracing.StatementTruncationMarker))
+	assert.True(t, strings.HasPrefix(query, strings.TrimSuffix(stmt, sqltracing.StatementTruncationMarker)))
+	assert.Equal(t, fmt.Sprint(len(query)), span.Tag(sqltracing.DBStatementLengthTagKey))
+	assert.NotEmpty(t, span.Tag(sqltracing.DBStatementHashTagKey))
+}
+
+func TestWithStatementBatches(t *testing.T) {
+	mockTracer, driverName := mustNewDBDriver(t, sqltracing.WithStatementBatches())
+	db := mustNewDB(t, driverName)
+
+	_, err := db.ExecContext(context.Background(), "CREATE TABLE a (x text); INSERT INTO a VALUES ('1;2'); -- done")
+	require.NoError(t, err)
+
+	span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+	assert.Equal(t, "2", span.Tag(sqltracing.DBStatementCountTagKey))
+	assert.Equal(t, "true", span.Tag(sqltracing.DBStatementMixedDDLDMLTagKey))
+
+	var events []map[string]string
+	for _, rec := range span.Logs() {
+		fields := map[string]string{}
+		for _, f := range rec.Fields {
+			fields[f.Key] = f.ValueString
+		}
+
+		events = append(events, fields)
+	}
+
+	assert.Equal(t, []map[string]string{
+		{
+			"event":                           sqltracing.BatchStatementEventName,
+			sqltracing.DBStatementIndexTagKey: "0",
+			sqltracing.DBOperationTagKey:      "CREATE",
+			sqltracing.DBSQLTableTagKey:       "a",
+		},
+		{
+			"event":                           sqltracing.BatchStatementEventName,
+			sqltracing.DBStatementIndexTagKey: "1",
+			sqltracing.DBOperationTagKey:      "INSERT",
+			sqltracing.DBSQLTableTagKey:       "a",
+		},
+	}, events)
+
+	mockTracer.Reset()
+
+	_, err = db.ExecContext(context.Background(), "SELECT 1;")
+	require.NoError(t, err)
+
+	span = findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+	assert.Nil(t, span.Tag(sqltracing.DBStatementCountTagKey))
+	assert.Empty(t, span.Logs())
+}
+
+func TestWithSQLCommenter(t *testing.T) {
+	driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+	mockTracer := mocktracer.New()
+	con := nullCon{}
+
+	sql.Register(
+		driverName,
+		sqltracing.WrapDriver(
+			&nullDriver{con: &con},
+			opentracing.NewTracer(
+				opentracing.WithTracer(
+					func() opentracing_go.Tracer { return newStrictTracer(mockTracer) },
+				),
+				opentracing.WithSpanIDs(func(sc opentracing_go.SpanContext) (string, string) {
+					msc := sc.(mocktracer.MockSpanContext)
+					return fmt.Sprintf("%x", msc.TraceID), fmt.Sprintf("%x", msc.SpanID)
+				}),
+				opentracing.WithSampled(func(sc opentracing_go.SpanContext) bool {
+					return sc.(mocktracer.MockSpanContext).Sampled
+				}),
+			),
+			sqltracing.WithSQLCommenter(sqltracing.NewSQLCommenter(
+				sqltracing.WithCommentApplication("my app"),
+			)),
+		),
+	)
+	db := mustNewDB(t, driverName)
+
+	ctx := sqltracing.WithRoute(context.Background(), "/users/{id}")
+
+	_, err := db.ExecContext(ctx, "DELETE FROM t;")
+	require.NoError(t, err)
+
+	span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+	msc := span.Context().(mocktracer.MockSpanContext)
+
+	assert.Equal(t, "DELETE FROM t;", span.Tag(sqltracing.DBStatementTagKey))
+	assert.Equal(t,
+		fmt.Sprintf(
+			"DELETE FROM t /*application='my%%20app',route='%%2Fusers%%2F%%7Bid%%7D',traceparent='00-%032x-%016x-01'*/;",
+			msc.TraceID, msc.SpanID,
+		),
+		con.lastQuery,
+	)
+
+	t.Run("existing comment", func(t *testing.T) {
+		_, err := db.QueryContext(ctx, "SELECT 1 /* c */")
+		require.NoError(t, err)
+		assert.Equal(t, "SELECT 1 /* c */", con.lastQuery)
+	})
+
+	t.Run("args", func(t *testing.T) {
+		_, err := db.ExecContext(ctx, "DELETE FROM t WHERE id = $1", 1)
+		require.NoError(t, err)
+		assert.Equal(t, "DELETE FROM t WHERE id = $1", con.lastQuery)
+	})
+
+	t.Run("prepare", func(t *testing.T) {
+		stmt, err := db.PrepareContext(ctx, "SELECT 1")
+		require.NoError(t, err)
+		require.NoError(t, stmt.Close())
+		assert.Equal(t, "SELECT 1", con.lastQuery)
+	})
+
+	t.Run("unsampled", func(t *testing.T) {
+		mockTracer.Reset()
+
+		parent := mockTracer.StartSpan("parent")
+		parent.SetTag("sampling.priority", 0)
+
+		_, err := db.ExecContext(opentracing_go.ContextWithSpan(ctx, parent), "DELETE FROM t")
+		require.NoError(t, err)
+
+		span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+		require.NotNil(t, span)
+		msc := span.Context().(mocktracer.MockSpanContext)
+
+		assert.True(t, strings.HasSuffix(con.lastQuery, fmt.Sprintf("traceparent='00-%032x-%016x-00'*/", msc.TraceID, msc.SpanID)), con.lastQuery)
+	})
+}
+
+func TestWithOnConnect(t *testing.T) {
+	mockTracer, driverName := mustNewDBDriver(t, sqltracing.WithOnConnect(
+		func(ctx context.Context, conn driver.Conn) (map[string]string, error) {
+			_, err := conn.(driver.ExecerContext).ExecContext(ctx, "SET application_name = 'test'", nil)
+			if err != nil {
+				return nil, err
+			}
+
+			rows, err := conn.(driver.QueryerContext).QueryContext(ctx, "SELECT pg_backend_pid()", nil)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+
+			return map[string]string{"db.backend_pid": "42"}, nil
+		},
+	))
+	db := mustNewDB(t, driverName)
+
+	require.NoError(t, db.PingContext(context.Background()))
+
+	assertIsParentSpanOp(t, mockTracer, sqltracing.OpSQLConnect, sqltracing.OpSQLConnExec)
+	assertIsParentSpanOp(t, mockTracer, sqltracing.OpSQLConnect, sqltracing.OpSQLConnQuery)
+	assertIsParentSpanOp(t, mockTracer, sqltracing.OpSQLConnQuery, sqltracing.OpSQLRowsClose)
+
+	span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLPing.String())
+	require.NotNil(t, span)
+	assert.Equal(t, "42", span.Tag("db.backend_pid"))
+
+	mockTracer.Reset()
+
+	rows, err := db.QueryContext(context.Background(), "SELECT 1")
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+
+	for _, op := range []sqltracing.SQLOp{sqltracing.OpSQLConnQuery, sqltracing.OpSQLRowsClose} {
+		span := findFinishedSpan(t, mockTracer, op.String())
+		require.NotNil(t, span)
+		assert.Equalf(t, "42", span.Tag("db.backend_pid"), "span %s", op)
+	}
+}
+
+func TestWithOnConnectError(t *testing.T) {
+	mockTracer, driverName := mustNewDBDriver(t, sqltracing.WithOnConnect(
+		func(context.Context, driver.Conn) (map[string]string, error) {
+			return nil, errors.New("init failed")
+		},
+	))
+	db := mustNewDB(t, driverName)
+
+	require.EqualError(t, db.PingContext(context.Background()), "init failed")
+
+	span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnect.String())
+	require.NotNil(t, span)
+	assert.Equal(t, true, span.Tag("error"))
+}
+
+func TestWithTimeout(t *testing.T) {
+	driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+	mockTracer := mocktracer.New()
+	con := nullCon{block: true}
+
+	sql.Register(
+		driverName,
+		sqltracing.WrapDriver(
+			&nullDriver{con: &con},
+			opentracing.NewTracer(
+				opentracing.WithTracer(
+					func() opentracing_go.Tracer { return newStrictTracer(mockTracer) },
+				),
+			),
+			sqltracing.WithTimeout(sqltracing.OpSQLConnExec, 10*time.Millisecond),
+			sqltracing.WithQueryNameTimeout("slow", 20*time.Millisecond),
+		),
+	)
+	db := mustNewDB(t, driverName)
+
+	t.Run("op-timeout", func(t *testing.T) {
+		mockTracer.Reset()
+
+		_, err := db.ExecContext(context.Background(), "UPDATE t SET a = 1")
+		require.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+
+		span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+		require.NotNil(t, span)
+		assert.Equal(t, "10ms", span.Tag(sqltracing.DBTimeoutTagKey))
+		assert.Equal(t, "true", span.Tag(sqltracing.DBTimeoutExceededTagKey))
+	})
+
+	t.Run("query-name-timeout", func(t *testing.T) {
+		mockTracer.Reset()
+
+		ctx := sqltracing.WithQueryName(context.Background(), "slow")
+		_, err := db.ExecContext(ctx, "UPDATE t SET a = 1")
+		require.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+
+		span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+		require.NotNil(t, span)
+		assert.Equal(t, "20ms", span.Tag(sqltracing.DBTimeoutTagKey))
+		assert.Equal(t, "true", span.Tag(sqltracing.DBTimeoutExceededTagKey))
+	})
+
+	t.Run("earlier-caller-deadline", func(t *testing.T) {
+		mockTracer.Reset()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		defer cancel()
+
+		_, err := db.ExecContext(ctx, "UPDATE t SET a = 1")
+		require.Error(t, err)
+
+		span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+		require.NotNil(t, span)
+		assert.Nil(t, span.Tag(sqltracing.DBTimeoutTagKey))
+		assert.Nil(t, span.Tag(sqltracing.DBTimeoutExceededTagKey))
+	})
+
+	t.Run("excluded-op", func(t *testing.T) {
+		driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+
+		sql.Register(
+			driverName,
+			sqltracing.WrapDriver(
+				&nullDriver{con: &nullCon{block: true}},
+				opentracing.NewTracer(
+					opentracing.WithTracer(
+						func() opentracing_go.Tracer { return newStrictTracer(mockTracer) },
+					),
+				),
+				sqltracing.WithTimeout(sqltracing.OpSQLConnExec, 10*time.Millisecond),
+				sqltracing.WithOpsExcluded(sqltracing.OpSQLConnExec),
+			),
+		)
+		db := mustNewDB(t, driverName)
+
+		const callerTimeout = 100 * time.Millisecond
+
+		ctx, cancel := context.WithTimeout(context.Background(), callerTimeout)
+		defer cancel()
+
+		start := time.Now()
+		_, err := db.ExecContext(ctx, "UPDATE t SET a = 1")
+		require.Error(t, err)
+
+		assert.GreaterOrEqual(t, int64(time.Since(start)), int64(callerTimeout), "timeout was applied to excluded operation")
+	})
+}
+
+func TestWithGuard(t *testing.T) {
+	var violations []*sqltracing.GuardViolation
+
+	mockTracer, driverName := mustNewDBDriver(t, sqltracing.WithGuard(sqltracing.NewGuard(
+		sqltracing.WithGuardRules(append(
+			sqltracing.DefaultGuardRules(),
+			sqltracing.GuardMaxLength(60),
+		)...),
+		sqltracing.WithGuardCallback(func(_ context.Context, v *sqltracing.GuardViolation) {
+			violations = append(violations, v)
+		}),
+	)))
+	db := mustNewDB(t, driverName)
+
+	tests := []struct {
+		query string
+		want  interface{}
+	}{
+		{query: "UPDATE t SET a = 1", want: "missing_where"},
+		{query: "delete from t", want: "missing_where"},
+		{query: "UPDATE t SET a = 1 WHERE id = $1"},
+		{query: "DELETE FROM t WHERE id IN (SELECT id FROM u WHERE b = 1)"},
+		{query: "SELECT * FROM t WHERE id = $1 LIMIT 1", want: "select_star"},
+		{query: "SELECT t.* FROM t WHERE id = $1 LIMIT 1", want: "select_star"},
+		{query: "SELECT count(*), a * 2 FROM t LIMIT 1"},
+		{query: "SELECT a, b FROM t WHERE c = $1", want: "missing_limit"},
+		{query: "SELECT a FROM t FETCH FIRST 10 ROWS ONLY"},
+		{query: "SELECT COUNT(*) AS cnt, max(a) FROM t"},
+		{query: "SELECT count(*) FROM t GROUP BY a", want: "missing_limit"},
+		{query: "SELECT 1"},
+		{query: "SELECT a FROM t LIMIT 1; DELETE FROM u", want: "missing_where"},
+		{query: "SELECT * FROM t; SELECT a FROM t WHERE b = 'a long string literal'", want: "select_star,missing_limit,max_length"},
+	}
+
+	for _, tt := range tests {
+		mockTracer.Reset()
+
+		_, err := db.ExecContext(context.Background(), tt.query)
+		require.NoError(t, err)
+
+		span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+		require.NotNil(t, span)
+		assert.Equalf(t, tt.want, span.Tag(sqltracing.DBGuardViolationsTagKey), "query: %s", tt.query)
+	}
+
+	require.NotEmpty(t, violations)
+	assert.Equal(t, "missing_where", violations[0].Rule)
+	assert.Equal(t, "UPDATE without WHERE clause", violations[0].Message)
+	assert.Equal(t, sqltracing.OpSQLConnExec, violations[0].Op)
+	assert.Equal(t, "UPDATE t SET a = 1", violations[0].Statement)
+}
+
+func TestWithGuardReject(t *testing.T) {
+	driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+	mockTracer := mocktracer.New()
+	con := nullCon{}
+
+	sql.Register(
+		driverName,
+		sqltracing.WrapDriver(
+			&nullDriver{con: &con},
+			opentracing.NewTracer(
+				opentracing.WithTracer(
+					func() opentracing_go.Tracer { return newStrictTracer(mockTracer) },
+				),
+			),
+			sqltracing.WithGuard(sqltracing.NewGuard(
+				sqltracing.WithGuardRules(sqltracing.GuardMissingWhere()),
+				sqltracing.WithGuardReject(),
+			)),
+		),
+	)
+	db := mustNewDB(t, driverName)
+
+	_, err := db.ExecContext(context.Background(), "DELETE FROM t")
+	var guardErr *sqltracing.GuardError
+	require.True(t, errors.As(err, &guardErr), "unexpected error: %v", err)
+	require.Len(t, guardErr.Violations, 1)
+	assert.EqualError(t, err, "sqltracing: query violates guard rules: missing_where: DELETE without WHERE clause")
+	assert.Empty(t, con.lastQuery)
+
+	span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+	assert.Equal(t, true, span.Tag("error"))
+
+	_, err = db.PrepareContext(context.Background(), "UPDATE t SET a = 1")
+	assert.True(t, errors.As(err, &guardErr), "unexpected error: %v", err)
+
+	_, err = db.ExecContext(context.Background(), "DELETE FROM t WHERE id = 1")
+	require.NoError(t, err)
+	assert.Equal(t, "DELETE FROM t WHERE id = 1", con.lastQuery)
+}
+
+func TestExplainer(t *testing.T) {
+	explainDriverName := "mockdb-explain-" + fmt.Sprint(time.Now().UnixNano())
+	explainCon := nullCon{rows: [][]driver.Value{{"Seq Scan on t"}, {"Filter: (id = $2)"}}}
+	sql.Register(explainDriverName, &nullDriver{con: &explainCon})
+	explainDB := mustNewDB(t, explainDriverName)
+
+	results := make(chan *sqltracing.ExplainResult, 1)
+
+	driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+	mockTracer := mocktracer.New()
+	con := nullCon{block: true}
+
+	sql.Register(
+		driverName,
+		sqltracing.WrapDriver(
+			&nullDriver{con: &con},
+			opentracing.NewTracer(
+				opentracing.WithTracer(
+					func() opentracing_go.Tracer { return newStrictTracer(mockTracer) },
+				),
+			),
+			sqltracing.WithTimeout(sqltracing.OpSQLConnExec, 200*time.Millisecond),
+			sqltracing.WithExplainer(sqltracing.NewExplainer(
+				explainDB,
+				sqltracing.WithExplainThreshold(time.Millisecond),
+				sqltracing.WithExplainCallback(func(res *sqltracing.ExplainResult) {
+					results <- res
+				}),
+			)),
+		),
+	)
+	db := mustNewDB(t, driverName)
+
+	const query = "UPDATE t SET a = $1 WHERE id = $2"
+
+	_, err := db.ExecContext(context.Background(), query, "x", 1)
+	require.Error(t, err)
+
+	var res *sqltracing.ExplainResult
+	select {
+	case res = <-results:
+	case <-time.After(5 * time.Second):
+		t.Fatal("explain result was not reported")
+	}
+
+	require.NoError(t, res.Err)
+	assert.Equal(t, query, res.Query)
+	assert.Equal(t, "Seq Scan on t\nFilter: (id = $2)", res.Plan)
+	assert.Equal(t, "EXPLAIN (FORMAT JSON) "+query, explainCon.lastQuery)
+
+	explainEvents := func(span *mocktracer.MockSpan) []map[string]string {
+		var events []map[string]string
+		for _, rec := range span.Logs() {
+			fields := map[string]string{}
+			for _, f := range rec.Fields {
+				fields[f.Key] = f.ValueString
+			}
+
+			if fields["event"] == sqltracing.ExplainEventName {
+				events = append(events, fields)
+			}
+		}
+
+		return events
+	}
+
+	span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+	assert.Equal(t, []map[string]string{
+		{"event": sqltracing.ExplainEventName, sqltracing.DBPlanTagKey: res.Plan},
+	}, explainEvents(span))
+
+	// queries with the same fingerprint are explained once per interval
+	mockTracer.Reset()
+
+	_, err = db.ExecContext(context.Background(), query, "y", 2)
+	require.Error(t, err)
+
+	assert.Empty(t, results)
+
+	span = findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+	assert.Empty(t, explainEvents(span))
+}
+
+func TestExplainerFailed(t *testing.T) {
+	explainDriverName := "mockdb-explain-" + fmt.Sprint(time.Now().UnixNano())
+	explainErr := errors.New("explain failed")
+	explainCon := nullCon{err: explainErr}
+	sql.Register(explainDriverName, &nullDriver{con: &explainCon})
+	explainDB := mustNewDB(t, explainDriverName)
+
+	results := make(chan *sqltracing.ExplainResult, 1)
+
+	driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+	mockTracer := mocktracer.New()
+	con := nullCon{block: true}
+
+	sql.Register(
+		driverName,
+		sqltracing.WrapDriver(
+			&nullDriver{con: &con},
+			opentracing.NewTracer(
+				opentracing.WithTracer(
+					func() opentracing_go.Tracer { return newStrictTracer(mockTracer) },
+				),
+			),
+			sqltracing.WithTimeout(sqltracing.OpSQLConnExec, 200*time.Millisecond),
+			sqltracing.WithExplainer(sqltracing.NewExplainer(
+				explainDB,
+				sqltracing.WithExplainThreshold(time.Millisecond),
+				sqltracing.WithExplainConcurrency(0),
+				sqltracing.WithExplainCallback(func(res *sqltracing.ExplainResult) {
+					results <- res
+				}),
+			)),
+		),
+	)
+	db := mustNewDB(t, driverName)
+
+	const query = "DELETE FROM t WHERE id = $1"
+
+	_, err := db.ExecContext(context.Background(), query, 1)
+	require.Error(t, err)
+
+	var res *sqltracing.ExplainResult
+	select {
+	case res = <-results:
+	case <-time.After(5 * time.Second):
+		t.Fatal("explain result was not reported")
+	}
+
+	assert.True(t, errors.Is(res.Err, explainErr))
+	assert.Equal(t, query, res.Query)
+	assert.Empty(t, res.Plan)
+
+	span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnExec.String())
+	require.NotNil(t, span)
+	assert.NotContains(t, span.Tags(), sqltracing.DBPlanTagKey)
+
+	for _, rec := range span.Logs() {
+		for _, f := range rec.Fields {
+			assert.NotEqual(t, sqltracing.ExplainEventName, f.ValueString)
+			assert.NotEqual(t, sqltracing.DBPlanTagKey, f.Key)
+		}
+	}
+}
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string {
+	return "sqlstate " + string(e)
+}
+
+func (e sqlStateError) SQLState() string {
+	return string(e)
+}
+
+func TestRunInTx(t *testing.T) {
+	driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+	mockTracer := mocktracer.New()
+	tracer := opentracing.NewTracer(
+		opentracing.WithTracer(
+			func() opentracing_go.Tracer { return newStrictTracer(mockTracer) },
+		),
+	)
+	con := nullCon{}
+
+	sql.Register(driverName, sqltracing.WrapDriver(&nullDriver{con: &con}, tracer))
+	db := mustNewDB(t, driverName)
+
+	opts := sqltracing.RunInTxOpts{
+		Tracer:  tracer,
+		Backoff: func(int) time.Duration { return 0 },
+	}
+
+	attemptSpans := func() []*mocktracer.MockSpan {
+		var spans []*mocktracer.MockSpan
+		for _, span := range mockTracer.FinishedSpans() {
+			if span.OperationName == sqltracing.TxAttemptSpanName {
+				spans = append(spans, span)
+			}
+		}
+
+		return spans
+	}
+
+	t.Run("retry", func(t *testing.T) {
+		mockTracer.Reset()
+
+		errs := []error{
+			sqlStateError(sqltracing.SQLStateSerializationFailure),
+			fmt.Errorf("wrapped: %w", sqlStateError(sqltracing.SQLStateDeadlockDetected)),
+			nil,
+		}
+		calls := 0
+
+		err := sqltracing.RunInTx(context.Background(), db, &opts, func(ctx context.Context, tx *sql.Tx) error {
+			con.err = errs[calls]
+			calls++
+
+			_, err := tx.ExecContext(ctx, "UPDATE t SET a = 1 WHERE id = 1")
+			return err
+		})
+		con.err = nil
+		require.NoError(t, err)
+		assert.Equal(t, 3, calls)
+
+		txSpan := findFinishedSpan(t, mockTracer, sqltracing.TxSpanName)
+		require.NotNil(t, txSpan)
+		assert.Equal(t, "3", txSpan.Tag(sqltracing.DBTxAttemptsTagKey))
+		assert.Nil(t, txSpan.Tag("error"))
+
+		spans := attemptSpans()
+		require.Len(t, spans, 3)
+
+		for i, span := range spans {
+			assert.Equal(t, txSpan.SpanContext.SpanID, span.ParentID)
+			assert.Equal(t, fmt.Sprint(i+1), span.Tag(sqltracing.DBTxAttemptTagKey))
+		}
+
+		assert.Equal(t, "serialization_failure", spans[0].Tag(sqltracing.DBTxFailureReasonTagKey))
+		assert.Equal(t, "deadlock_detected", spans[1].Tag(sqltracing.DBTxFailureReasonTagKey))
+		assert.Nil(t, spans[2].Tag(sqltracing.DBTxFailureReasonTagKey))
+
+		assertIsParentSpan(t, mockTracer, sqltracing.TxAttemptSpanName, sqltracing.OpSQLTxBegin.String())
+	})
+
+	t.Run("not-retryable", func(t *testing.T) {
+		mockTracer.Reset()
+
+		calls := 0
+		err := sqltracing.RunInTx(context.Background(), db, &opts, func(ctx context.Context, tx *sql.Tx) error {
+			calls++
+			return sqlStateError("23505")
+		})
+		require.EqualError(t, err, "sqlstate 23505")
+		assert.Equal(t, 1, calls)
+
+		spans := attemptSpans()
+		require.Len(t, spans, 1)
+		assert.Equal(t, "23505", spans[0].Tag(sqltracing.DBTxFailureReasonTagKey))
+		assertHasSpan(t, mockTracer, sqltracing.OpSQLTxRollback)
+
+		txSpan := findFinishedSpan(t, mockTracer, sqltracing.TxSpanName)
+		require.NotNil(t, txSpan)
+		assert.Equal(t, true, txSpan.Tag("error"))
+	})
+
+	t.Run("max-attempts", func(t *testing.T) {
+		mockTracer.Reset()
+
+		calls := 0
+		err := sqltracing.RunInTx(context.Background(), db, &sqltracing.RunInTxOpts{
+			MaxAttempts: 2,
+			Backoff:     opts.Backoff,
+		}, func(ctx context.Context, tx *sql.Tx) error {
+			calls++
+			return sqlStateError(sqltracing.SQLStateSerializationFailure)
+		})
+		require.EqualError(t, err, "sqlstate 40001")
+		assert.Equal(t, 2, calls)
+		assert.Nil(t, findFinishedSpan(t, mockTracer, sqltracing.TxSpanName))
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		mockTracer.Reset()
+
+		assert.PanicsWithValue(t, "boom", func() {
+			_ = sqltracing.RunInTx(context.Background(), db, &opts, func(ctx context.Context, tx *sql.Tx) error {
+				panic("boom")
+			})
+		})
+
+		spans := attemptSpans()
+		require.Len(t, spans, 1)
+		assert.Equal(t, "panic", spans[0].Tag(sqltracing.DBTxFailureReasonTagKey))
+		assert.Equal(t, true, spans[0].Tag("error"))
+		assertHasSpan(t, mockTracer, sqltracing.OpSQLTxRollback)
+
+		txSpan := findFinishedSpan(t, mockTracer, sqltracing.TxSpanName)
+		require.NotNil(t, txSpan)
+		assert.Equal(t, true, txSpan.Tag("error"))
+		assert.Equal(t, "1", txSpan.Tag(sqltracing.DBTxAttemptsTagKey))
+	})
+}
+
+func TestRowsTimings(t *testing.T) {
+	driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+	mockTracer := mocktracer.New()
+	con := nullCon{rows: [][]driver.Value{{int64(1)}, {int64(2)}}}
+
+	sql.Register(
+		driverName,
+		sqltracing.WrapDriver(
+			&nullDriver{con: &con},
+			opentracing.NewTracer(
+				opentracing.WithTracer(
+					func() opentracing_go.Tracer { return newStrictTracer(mockTracer) },
+				),
+			),
+		),
+	)
+	db := mustNewDB(t, driverName)
+
+	const idle = 20 * time.Millisecond
+
+	rows, err := db.QueryContext(context.Background(), "SELECT a FROM t")
+	require.NoError(t, err)
+
+	for rows.Next() {
+		time.Sleep(idle)
+	}
+
+	require.NoError(t, rows.Err())
+	require.NoError(t, rows.Close())
+
+	span := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnQuery.String())
+	require.NotNil(t, span)
+
+	durationTag := func(key string) time.Duration {
+		t.Helper()
+
+		v, ok := span.Tag(key).(string)
+		require.Truef(t, ok, "tag %s is missing", key)
+
+		d, err := time.ParseDuration(v)
+		require.NoError(t, err)
+
+		return d
+	}
+
+	ttfr := durationTag(sqltracing.DBTTFRTagKey)
+	fetch := durationTag(sqltracing.DBFetchDurationTagKey)
+	clientIdle := durationTag(sqltracing.DBClientIdleTagKey)
+
+	assert.Less(t, int64(ttfr), int64(idle))
+	assert.GreaterOrEqual(t, int64(fetch), int64(2*idle))
+	assert.GreaterOrEqual(t, int64(clientIdle), int64(2*idle))
+	assert.Less(t, int64(clientIdle), int64(span.FinishTime.Sub(span.StartTime)))
+}
+
+func TestStatementOptionalInterfaces(t *testing.T) {
+	driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+	mockTracer := mocktracer.New()
+	stmt := ctxStmt{}
+	con := nullCon{stmt: &stmt}
+
+	sql.Register(
+		driverName,
+		sqltracing.WrapDriver(
+			&nullDriver{con: &con},
+			opentracing.NewTracer(
+				opentracing.WithTracer(
+					func() opentracing_go.Tracer { return newStrictTracer(mockTracer) },
+				),
+			),
+		),
+	)
+	db := mustNewDB(t, driverName)
+
+	s, err := db.PrepareContext(context.Background(), "UPDATE t SET a = $1")
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.ExecContext(context.Background(), customArg{value: "x"})
+	require.NoError(t, err)
+	assert.NotNil(t, stmt.lastCtx, "ExecContext of the statement was not called")
+	assertHasSpan(t, mockTracer, sqltracing.OpSQLStmtExec)
+
+	stmt.lastCtx = nil
+
+	rows, err := s.QueryContext(context.Background(), customArg{value: "x"})
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	assert.NotNil(t, stmt.lastCtx, "QueryContext of the statement was not called")
+	assertHasSpan(t, mockTracer, sqltracing.OpSQLStmtQuery)
+}
+
+func TestRowsOptionalInterfaces(t *testing.T) {
+	driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+	mockTracer := mocktracer.New()
+	resultSets := [][][]driver.Value{
+		{{int64(1)}},
+		{{int64(2)}, {int64(3)}},
+	}
+	stmt := ctxStmt{}
+	con := nullCon{resultSets: resultSets, stmt: &stmt}
+
+	sql.Register(
+		driverName,
+		sqltracing.WrapDriver(
+			&nullDriver{con: &con},
+			opentracing.NewTracer(
+				opentracing.WithTracer(
+					func() opentracing_go.Tracer { return newStrictTracer(mockTracer) },
+				),
+			),
+		),
+	)
+	db := mustNewDB(t, driverName)
+
+	readResultSets := func(t *testing.T, rows *sql.Rows) [][]int64 {
+		var result [][]int64
+
+		for {
+			types, err := rows.ColumnTypes()
+			require.NoError(t, err)
+			require.Len(t, types, 1)
+			assert.Equal(t, "INT8", types[0].DatabaseTypeName())
+			assert.Equal(t, reflect.TypeOf(int64(0)), types[0].ScanType())
+
+			nullable, ok := types[0].Nullable()
+			assert.True(t, ok)
+			assert.True(t, nullable)
+
+			var values []int64
+			for rows.Next() {
+				var v int64
+				require.NoError(t, rows.Scan(&v))
+				values = append(values, v)
+			}
+
+			result = append(result, values)
+
+			if !rows.NextResultSet() {
+				break
+			}
+		}
+
+		require.NoError(t, rows.Err())
+		require.NoError(t, rows.Close())
+
+		return result
+	}
+
+	t.Run("conn", func(t *testing.T) {
+		mockTracer.Reset()
+
+		rows, err := db.QueryContext(context.Background(), "SELECT 1; SELECT a FROM t")
+		require.NoError(t, err)
+
+		assert.Equal(t, [][]int64{{1}, {2, 3}}, readResultSets(t, rows))
+
+		var nextResultSetSpans []*mocktracer.MockSpan
+		for _, span := range mockTracer.FinishedSpans() {
+			if span.OperationName == sqltracing.OpSQLRowsNextResultSet.String() {
+				nextResultSetSpans = append(nextResultSetSpans, span)
+			}
+		}
+
+		// the last NextResultSet call is not passed to the driver
+		// because HasNextResultSet returned false
+		require.Len(t, nextResultSetSpans, 1)
+		assert.Nil(t, nextResultSetSpans[0].Tag("error"))
+		assertIsParentSpanOp(t, mockTracer, sqltracing.OpSQLConnQuery, sqltracing.OpSQLRowsNextResultSet)
+	})
+
+	t.Run("stmt", func(t *testing.T) {
+		mockTracer.Reset()
+		stmt.rows = newResultSetRows(resultSets...)
+
+		s, err := db.PrepareContext(context.Background(), "SELECT 1; SELECT a FROM t")
+		require.NoError(t, err)
+		defer s.Close()
+
+		rows, err := s.QueryContext(context.Background())
+		require.NoError(t, err)
+
+		assert.Equal(t, [][]int64{{1}, {2, 3}}, readResultSets(t, rows))
+		assertIsParentSpanOp(t, mockTracer, sqltracing.OpSQLStmtQuery, sqltracing.OpSQLRowsNextResultSet)
+	})
+
+	t.Run("not-implemented", func(t *testing.T) {
+		mockTracer.Reset()
+		stmt.rows = &valueRows{values: [][]driver.Value{{int64(1)}}}
+
+		s, err := db.PrepareContext(context.Background(), "SELECT 1")
+		require.NoError(t, err)
+		defer s.Close()
+
+		rows, err := s.QueryContext(context.Background())
+		require.NoError(t, err)
+
+		types, err := rows.ColumnTypes()
+		require.NoError(t, err)
+		require.Len(t, types, 1)
+		assert.Empty(t, types[0].DatabaseTypeName())
+
+		_, ok := types[0].Nullable()
+		assert.False(t, ok)
+
+		for rows.Next() {
+		}
+
+		assert.False(t, rows.NextResultSet())
+		require.NoError(t, rows.Err())
+		require.NoError(t, rows.Close())
+
+		assertHasNotSpan(t, mockTracer, sqltracing.OpSQLRowsNextResultSet)
+	})
+}
+
+func TestConnLifecycle(t *testing.T) {
+	driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+	mockTracer := mocktracer.New()
+	con := validatingCon{}
+
+	sql.Register(
+		driverName,
+		sqltracing.WrapDriver(
+			&nullDriver{con: &con},
+			opentracing.NewTracer(
+				opentracing.WithTracer(
+					func() opentracing_go.Tracer { return newStrictTracer(mockTracer) },
+				),
+			),
+			sqltracing.WithConnLifetimeSpans(),
+		),
+	)
+	db := mustNewDB(t, driverName)
+	db.SetMaxOpenConns(1)
+
+	parentSpan := mockTracer.StartSpan("parent")
+	_, err := db.ExecContext(opentracing_go.ContextWithSpan(context.Background(), parentSpan), "UPDATE t SET a = 1")
+	require.NoError(t, err)
+	parentSpan.Finish()
+
+	_, err = db.ExecContext(context.Background(), "UPDATE t SET a = 2")
+	require.NoError(t, err)
+
+	assertHasSpan(t, mockTracer, sqltracing.OpSQLResetSession)
+	assertHasNotSpan(t, mockTracer, sqltracing.OpSQLConnClose)
+	assert.Nil(t, findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnLifetime.String()))
+
+	con.invalid = true
+
+	_, err = db.ExecContext(context.Background(), "UPDATE t SET a = 3")
+	require.NoError(t, err)
+
+	lifetimeSpan := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnLifetime.String())
+	require.NotNil(t, lifetimeSpan)
+	assert.Equal(t, "3", lifetimeSpan.Tag(sqltracing.DBConnOpsTagKey))
+
+	require.Len(t, lifetimeSpan.Logs(), 1)
+	assert.Equal(t, sqltracing.ConnInvalidEventName, lifetimeSpan.Logs()[0].Fields[0].ValueString)
+	assert.Equal(t, sqltracing.ConnInvalidReasonIsValid, lifetimeSpan.Logs()[0].Fields[1].ValueString)
+
+	closeSpan := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnClose.String())
+	require.NotNil(t, closeSpan)
+	assert.Equal(t, "true", closeSpan.Tag(sqltracing.DBConnInvalidTagKey))
+	assert.Equal(t, sqltracing.ConnInvalidReasonIsValid, closeSpan.Tag(sqltracing.DBConnInvalidReasonTagKey))
+	assert.Equal(t, lifetimeSpan.SpanContext.SpanID, closeSpan.ParentID)
+
+	// the lifetime span is a root span, the connect span a child of the
+	// operation that opened the connection
+	connectSpan := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnect.String())
+	require.NotNil(t, connectSpan)
+	assert.Equal(t, parentSpan.Context().(mocktracer.MockSpanContext).SpanID, connectSpan.ParentID)
+	assert.Zero(t, lifetimeSpan.ParentID)
+}
+
+func TestConnLifecycleWithoutLifetimeSpans(t *testing.T) {
+	driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
+	mockTracer := mocktracer.New()
+	con := validatingCon{}
+
+	sql.Register(
+		driverName,
+		sqltracing.WrapDriver(
+			&nullDriver{con: &con},
+			opentracing.NewTracer(
+				opentracing.WithTracer(
+					func() opentracing_go.Tracer { return newStrictTracer(mockTracer) },
+				),
+			),
+		),
+	)
+	db := mustNewDB(t, driverName)
+	db.SetMaxOpenConns(1)
+
+	_, err := db.ExecContext(context.Background(), "UPDATE t SET a = 1")
+	require.NoError(t, err)
+
+	_, err = db.ExecContext(context.Background(), "UPDATE t SET a = 2")
+	require.NoError(t, err)
+
+	assertHasNotSpan(t, mockTracer, sqltracing.OpSQLResetSession)
+	assertHasNotSpan(t, mockTracer, sqltracing.OpSQLConnLifetime)
+
+	// connections that are closed because they are invalid are recorded
+	con.invalid = true
+
+	_, err = db.ExecContext(context.Background(), "UPDATE t SET a = 3")
+	require.NoError(t, err)
+
+	closeSpan := findFinishedSpan(t, mockTracer, sqltracing.OpSQLConnClose.String())
+	require.NotNil(t, closeSpan)
+	assert.Equal(t, "true", closeSpan.Tag(sqltracing.DBConnInvalidTagKey))
+	assert.Equal(t, sqltracing.ConnInvalidReasonIsValid, closeSpan.Tag(sqltracing.DBConnInvalidReasonTagKey))
+
+	require.Len(t, closeSpan.Logs(), 1)
+	assert.Equal(t, sqltracing.ConnInvalidEventName, closeSpan.Logs()[0].Fields[0].ValueString)
+	assert.Equal(t, sqltracing.ConnInvalidReasonIsValid, closeSpan.Logs()[0].Fields[1].ValueString)
+
+	// valid connections are closed without a span
+	con.invalid = false
+
+	_, err = db.ExecContext(context.Background(), "UPDATE t SET a = 4")
+	require.NoError(t, err)
+
+	mockTracer.Reset()
+	require.NoError(t, db.Close())
+	assertHasNotSpan(t, mockTracer, sqltracing.OpSQLConnClose)
+}
+
 func TestWithoutTracingOrphans(t *testing.T) {
 	driverName := "traced-mockdb-" + fmt.Sprint(time.Now().UnixNano())
 
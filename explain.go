@@ -0,0 +1,368 @@
+package sqltracing
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/simplesurance/sqltracing/querynorm"
+)
+
+// DBPlanTagKey is the name of the tracing tag or event attribute that
+// contains the query plan that was captured by an Explainer.
+const DBPlanTagKey = "db.plan"
+
+// ExplainEventName is the name of the span event that contains the query
+// plan that was captured by an Explainer.
+const ExplainEventName = "db.explain"
+
+// Default values of the Explainer.
+const (
+	// DefaultExplainThreshold is the duration after which a running
+	// operation is considered slow and its query is explained.
+	DefaultExplainThreshold = time.Second
+	// DefaultExplainInterval is the minimum duration between two
+	// explanations of queries with the same fingerprint.
+	DefaultExplainInterval = 10 * time.Minute
+	// DefaultExplainTimeout is the maximum duration of an EXPLAIN query.
+	DefaultExplainTimeout = 10 * time.Second
+	// DefaultExplainConcurrency is the maximum number of EXPLAIN queries
+	// that are run in parallel.
+	DefaultExplainConcurrency = 2
+)
+
+// ExplainResult is the plan of a slow query.
+type ExplainResult struct {
+	// Query is the explained query.
+	Query       string
+	Fingerprint uint64
+	// Plan is the output of the EXPLAIN query. Columns are separated by
+	// tabs, rows by newlines.
+	Plan string
+	// Err is the error of the EXPLAIN query.
+	Err error
+	// TraceID is the ID of the trace of the slow operation, if the Span
+	// implements SpanIDer.
+	TraceID string
+}
+
+// Explainer captures the plans of slow queries by running EXPLAIN for them.
+//
+// When an OpSQLConnExec, OpSQLConnQuery, OpSQLStmtExec or OpSQLStmtQuery
+// operation with a DML statement runs longer than the threshold, an
+// EXPLAIN query for the same query and arguments is run asynchronously on
+// the configured *sql.DB. The duration of queries includes reading their
+// rows. Queries with the same fingerprint are explained at most once per
+// interval, when the maximum number of concurrent EXPLAIN queries is
+// reached, slow queries are not explained.
+//
+// If the plan is available when the operation finishes, it is recorded on
+// its span as ExplainEventName event, if the span implements SpanEventer,
+// otherwise as DBPlanTagKey tag. Plans are always reported to the callback.
+//
+// Queries of the *sql.DB are not explained, also when it uses the traced
+// driver, because EXPLAIN statements are not DML statements.
+type Explainer struct {
+	db          *sql.DB
+	threshold   time.Duration
+	interval    time.Duration
+	timeout     time.Duration
+	statementFn func(dialect querynorm.Dialect, query string) string
+	callback    func(*ExplainResult)
+	sem         chan struct{}
+
+	mu        sync.Mutex
+	explained map[uint64]time.Time
+	lastSweep time.Time
+}
+
+// ExplainerOpt is a type for options for the Explainer.
+type ExplainerOpt func(*Explainer)
+
+// WithExplainThreshold sets the duration after which a running operation
+// is explained. The default is DefaultExplainThreshold.
+func WithExplainThreshold(threshold time.Duration) ExplainerOpt {
+	return func(e *Explainer) {
+		e.threshold = threshold
+	}
+}
+
+// WithExplainInterval sets the minimum duration between two explanations
+// of queries with the same fingerprint. The default is
+// DefaultExplainInterval.
+func WithExplainInterval(interval time.Duration) ExplainerOpt {
+	return func(e *Explainer) {
+		e.interval = interval
+	}
+}
+
+// WithExplainTimeout sets the maximum duration of EXPLAIN queries. The
+// default is DefaultExplainTimeout.
+func WithExplainTimeout(timeout time.Duration) ExplainerOpt {
+	return func(e *Explainer) {
+		e.timeout = timeout
+	}
+}
+
+// WithExplainConcurrency sets the maximum number of EXPLAIN queries that
+// are run in parallel. The default is DefaultExplainConcurrency.
+// Values smaller than 1 are treated as 1.
+func WithExplainConcurrency(n int) ExplainerOpt {
+	return func(e *Explainer) {
+		if n < 1 {
+			n = 1
+		}
+
+		e.sem = make(chan struct{}, n)
+	}
+}
+
+// WithExplainStatement sets the function that returns the EXPLAIN
+// statement for a query. The default is DefaultExplainStatement.
+func WithExplainStatement(fn func(dialect querynorm.Dialect, query string) string) ExplainerOpt {
+	return func(e *Explainer) {
+		e.statementFn = fn
+	}
+}
+
+// WithExplainCallback sets a function that is called with the results of
+// EXPLAIN queries.
+// It is called asynchronously, from the goroutine of the timer that
+// started the EXPLAIN query, also for EXPLAIN queries that failed.
+func WithExplainCallback(fn func(*ExplainResult)) ExplainerOpt {
+	return func(e *Explainer) {
+		e.callback = fn
+	}
+}
+
+// NewExplainer returns a new Explainer that runs EXPLAIN queries on db.
+// It is passed via WithExplainer to an Interceptor.
+func NewExplainer(db *sql.DB, opts ...ExplainerOpt) *Explainer {
+	e := Explainer{
+		db:          db,
+		threshold:   DefaultExplainThreshold,
+		interval:    DefaultExplainInterval,
+		timeout:     DefaultExplainTimeout,
+		statementFn: DefaultExplainStatement,
+		sem:         make(chan struct{}, DefaultExplainConcurrency),
+		explained:   map[uint64]time.Time{},
+		lastSweep:   time.Now(),
+	}
+
+	for _, opt := range opts {
+		opt(&e)
+	}
+
+	return &e
+}
+
+// DefaultExplainStatement returns the EXPLAIN statement for query.
+// For Postgres and MySQL the plan is requested in JSON format, for SQLite
+// "EXPLAIN QUERY PLAN" is used.
+func DefaultExplainStatement(dialect querynorm.Dialect, query string) string {
+	switch dialect {
+	case querynorm.DialectMySQL:
+		return "EXPLAIN FORMAT=JSON " + query
+	case querynorm.DialectSQLite:
+		return "EXPLAIN QUERY PLAN " + query
+	default:
+		return "EXPLAIN (FORMAT JSON) " + query
+	}
+}
+
+// explainOp is an operation that is explained when it exceeds the
+// threshold of the Explainer.
+type explainOp struct {
+	explainer *Explainer
+	dialect   querynorm.Dialect
+	query     string
+	parsed    *parsedQuery
+	args      []interface{}
+	span      Span
+	timer     *time.Timer
+
+	mu       sync.Mutex
+	finished bool
+	result   *ExplainResult
+}
+
+// start starts the timer that explains the operation when it exceeds the
+// threshold.
+// It returns nil if the query is not explained.
+func (e *Explainer) start(dialect querynorm.Dialect, query string, parsed *parsedQuery, args []driver.NamedValue, span Span) *explainOp {
+	if parsed.batch != nil || !querynorm.IsDML(parsed.operation) {
+		return nil
+	}
+
+	op := explainOp{
+		explainer: e,
+		dialect:   dialect,
+		query:     query,
+		parsed:    parsed,
+		args:      explainArgs(args),
+		span:      span,
+	}
+
+	op.timer = time.AfterFunc(e.threshold, op.explain)
+
+	return &op
+}
+
+// allow returns true if a query with the fingerprint was not explained
+// during the interval and records the explanation.
+func (e *Explainer) allow(fingerprint uint64) bool {
+	now := time.Now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if now.Sub(e.lastSweep) >= e.interval {
+		for fp, t := range e.explained {
+			if now.Sub(t) >= e.interval {
+				delete(e.explained, fp)
+			}
+		}
+
+		e.lastSweep = now
+	}
+
+	if t, exist := e.explained[fingerprint]; exist && now.Sub(t) < e.interval {
+		return false
+	}
+
+	e.explained[fingerprint] = now
+
+	return true
+}
+
+// explain runs the EXPLAIN query for the operation.
+func (o *explainOp) explain() {
+	e := o.explainer
+
+	select {
+	case e.sem <- struct{}{}:
+		defer func() { <-e.sem }()
+	default:
+		return
+	}
+
+	if !e.allow(o.parsed.fingerprint) {
+		return
+	}
+
+	traceID, _ := spanIDs(o.span)
+	result := ExplainResult{
+		Query:       o.query,
+		Fingerprint: o.parsed.fingerprint,
+		TraceID:     traceID,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	result.Plan, result.Err = queryPlan(ctx, e.db, e.statementFn(o.dialect, o.query), o.args)
+
+	o.mu.Lock()
+	if !o.finished {
+		o.result = &result
+	}
+	o.mu.Unlock()
+
+	if e.callback != nil {
+		e.callback(&result)
+	}
+}
+
+// finishFunc returns a function that stops the timer, records the plan on
+// the span if it is available and then calls finishFn.
+// If o is nil, finishFn is returned.
+func (o *explainOp) finishFunc(finishFn func(error)) func(error) {
+	if o == nil {
+		return finishFn
+	}
+
+	return func(err error) {
+		o.timer.Stop()
+
+		o.mu.Lock()
+		o.finished = true
+		result := o.result
+		o.mu.Unlock()
+
+		if result != nil && result.Err == nil {
+			if eventer, ok := o.span.(SpanEventer); ok {
+				eventer.AddEvent(ExplainEventName, map[string]string{DBPlanTagKey: result.Plan})
+			} else {
+				o.span.SetTag(DBPlanTagKey, result.Plan)
+			}
+		}
+
+		finishFn(err)
+	}
+}
+
+// queryPlan runs the EXPLAIN query and returns its result.
+func queryPlan(ctx context.Context, db *sql.DB, query string, args []interface{}) (string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+
+	values := make([]sql.NullString, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	var lines []string
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return "", err
+		}
+
+		fields := make([]string, 0, len(values))
+		for _, v := range values {
+			fields = append(fields, v.String)
+		}
+
+		lines = append(lines, strings.Join(fields, "\t"))
+	}
+
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// explainArgs converts the arguments of a query to arguments for the
+// EXPLAIN query. Byte slices are copied because they might be modified by
+// the caller after the operation returned.
+func explainArgs(args []driver.NamedValue) []interface{} {
+	result := make([]interface{}, 0, len(args))
+
+	for _, arg := range args {
+		v := arg.Value
+		if b, ok := v.([]byte); ok {
+			v = append([]byte(nil), b...)
+		}
+
+		if arg.Name != "" {
+			result = append(result, sql.Named(arg.Name, v))
+			continue
+		}
+
+		result = append(result, v)
+	}
+
+	return result
+}
@@ -0,0 +1,78 @@
+//go:build ignore
+// +build ignore
+
+// gen_outerrows generates outerrows_gen.go, that contains wrapOuterRows.
+// It creates a type for every combination of the optional driver.Rows
+// interfaces.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// rowsInterfaces are the optional driver.Rows interfaces, the outerRows
+// type that implements an interface is called "outer" + the name.
+var rowsInterfaces = []string{
+	"RowsNextResultSet",
+	"RowsColumnTypeScanType",
+	"RowsColumnTypeDatabaseTypeName",
+	"RowsColumnTypeLength",
+	"RowsColumnTypeNullable",
+	"RowsColumnTypePrecisionScale",
+}
+
+func main() {
+	var buf bytes.Buffer
+
+	buf.WriteString(`// Code generated by gen_outerrows.go; DO NOT EDIT.
+
+package sqltracing
+
+import "database/sql/driver"
+
+// wrapOuterRows returns r wrapped in a type that implements the optional
+// driver.Rows interfaces that are implemented by the driver rows of r.
+// If they implement none, r is returned.
+func wrapOuterRows(r *outerRows) driver.Rows {
+	var implemented int
+
+`)
+
+	for i, iface := range rowsInterfaces {
+		fmt.Fprintf(&buf, "if _, ok := r.traced.Rows.(driver.%s); ok {\nimplemented |= 1 << %d\n}\n\n", iface, i)
+	}
+
+	buf.WriteString("switch implemented {\n")
+
+	for combination := 1; combination < 1<<len(rowsInterfaces); combination++ {
+		var fields, values []string
+
+		for i, iface := range rowsInterfaces {
+			if combination&(1<<i) == 0 {
+				continue
+			}
+
+			fields = append(fields, "outer"+iface)
+			values = append(values, "outer"+iface+"{r}")
+		}
+
+		fmt.Fprintf(&buf, "case %d:\nreturn struct {\n*outerRows\n%s\n}{r, %s}\n",
+			combination, strings.Join(fields, "\n"), strings.Join(values, ", "))
+	}
+
+	buf.WriteString("default:\nreturn r\n}\n}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile("outerrows_gen.go", src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
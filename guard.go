@@ -0,0 +1,318 @@
+package sqltracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/simplesurance/sqltracing/querynorm"
+)
+
+// DBGuardViolationsTagKey is the name of the tracing tag that contains the
+// comma separated names of the GuardRules that a query violates.
+const DBGuardViolationsTagKey = "db.guard.violations"
+
+// DefaultGuardMaxQueryLength is the maximum query length in bytes of the
+// GuardMaxLength rule that is part of DefaultGuardRules.
+const DefaultGuardMaxQueryLength = 64 * 1024
+
+// GuardStatement is a statement of a query that is checked by GuardRules.
+// Queries that consist of multiple statements are checked per statement.
+type GuardStatement struct {
+	Op SQLOp
+	// Query is the complete query that was passed to the driver.
+	Query string
+	// Index is the position of the statement in the query.
+	Index int
+	// Statement is the checked statement of the query.
+	Statement string
+	// Tokens are the tokens of Statement, without comments.
+	Tokens []querynorm.Token
+	// Operation and Tables are the result of querynorm.AnalyzeTokens.
+	Operation string
+	Tables    []string
+}
+
+// The Tokens and Tables of GuardStatements are cached per query and shared
+// between checks, rules must not modify them.
+
+// GuardRule is a check for statements.
+type GuardRule struct {
+	// Name identifies the rule in tags, GuardViolations and errors.
+	Name string
+	// Check returns a description of the violation, if the statement
+	// violates the rule. Otherwise it returns an empty string.
+	Check func(stmt *GuardStatement) string
+}
+
+// GuardViolation describes a statement that violated a GuardRule.
+type GuardViolation struct {
+	Rule      string
+	Message   string
+	Op        SQLOp
+	Statement string
+}
+
+// GuardError is returned for queries that violate GuardRules, when the
+// Guard rejects them.
+type GuardError struct {
+	Violations []*GuardViolation
+}
+
+func (e *GuardError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, v.Rule+": "+v.Message)
+	}
+
+	return "sqltracing: query violates guard rules: " + strings.Join(msgs, ", ")
+}
+
+// Guard checks queries that are prepared or executed on connections for
+// dangerous patterns. It is meant to be used in development and CI
+// environments.
+//
+// Queries that violate a rule are tagged with DBGuardViolationsTagKey.
+// Additionally violations can be reported to a callback or the queries
+// can be rejected with a *GuardError.
+type Guard struct {
+	rules    []GuardRule
+	callback func(context.Context, *GuardViolation)
+	reject   bool
+}
+
+// GuardOpt is a type for options for the Guard.
+type GuardOpt func(*Guard)
+
+// WithGuardRules sets the rules that are checked. The default are
+// DefaultGuardRules.
+func WithGuardRules(rules ...GuardRule) GuardOpt {
+	return func(g *Guard) {
+		g.rules = rules
+	}
+}
+
+// WithGuardCallback sets a function that is called for every violation,
+// e.g. to log it.
+func WithGuardCallback(fn func(context.Context, *GuardViolation)) GuardOpt {
+	return func(g *Guard) {
+		g.callback = fn
+	}
+}
+
+// WithGuardReject enables rejecting queries that violate a rule. The
+// queries are not passed to the driver, instead a *GuardError is
+// returned.
+func WithGuardReject() GuardOpt {
+	return func(g *Guard) {
+		g.reject = true
+	}
+}
+
+// NewGuard returns a new Guard.
+func NewGuard(opts ...GuardOpt) *Guard {
+	g := Guard{
+		rules: DefaultGuardRules(),
+	}
+
+	for _, opt := range opts {
+		opt(&g)
+	}
+
+	return &g
+}
+
+// DefaultGuardRules returns GuardMissingWhere, GuardSelectStar,
+// GuardMissingLimit and GuardMaxLength with DefaultGuardMaxQueryLength.
+func DefaultGuardRules() []GuardRule {
+	return []GuardRule{
+		GuardMissingWhere(),
+		GuardSelectStar(),
+		GuardMissingLimit(),
+		GuardMaxLength(DefaultGuardMaxQueryLength),
+	}
+}
+
+// GuardMissingWhere returns a rule that reports UPDATE and DELETE
+// statements without WHERE clause.
+func GuardMissingWhere() GuardRule {
+	return GuardRule{
+		Name: "missing_where",
+		Check: func(stmt *GuardStatement) string {
+			if stmt.Operation != "UPDATE" && stmt.Operation != "DELETE" {
+				return ""
+			}
+
+			if hasTopLevelKeyword(stmt.Tokens, "WHERE") {
+				return ""
+			}
+
+			return stmt.Operation + " without WHERE clause"
+		},
+	}
+}
+
+// GuardSelectStar returns a rule that reports statements that select all
+// columns with "*" or "table.*".
+func GuardSelectStar() GuardRule {
+	return GuardRule{
+		Name: "select_star",
+		Check: func(stmt *GuardStatement) string {
+			for i := 1; i < len(stmt.Tokens); i++ {
+				if stmt.Tokens[i].Kind != querynorm.TokenOperator || stmt.Tokens[i].Text != "*" {
+					continue
+				}
+
+				prev := stmt.Tokens[i-1]
+				switch {
+				case prev.Kind == querynorm.TokenPunctuation && (prev.Text == "," || prev.Text == "."),
+					isKeywordToken(prev, "SELECT", "DISTINCT", "ALL"):
+					return "selects all columns with *"
+				}
+			}
+
+			return ""
+		},
+	}
+}
+
+// GuardMissingLimit returns a rule that reports SELECT statements that read
+// from tables without limiting the number of returned rows via LIMIT or
+// FETCH.
+// Statements that only select aggregate functions without GROUP BY clause
+// return a single row and are not reported.
+func GuardMissingLimit() GuardRule {
+	return GuardRule{
+		Name: "missing_limit",
+		Check: func(stmt *GuardStatement) string {
+			if stmt.Operation != "SELECT" || len(stmt.Tables) == 0 {
+				return ""
+			}
+
+			if hasTopLevelKeyword(stmt.Tokens, "LIMIT", "FETCH") {
+				return ""
+			}
+
+			if !hasTopLevelKeyword(stmt.Tokens, "GROUP") && querynorm.SelectsOnlyAggregates(stmt.Tokens) {
+				return ""
+			}
+
+			return "SELECT without LIMIT"
+		},
+	}
+}
+
+// GuardMaxLength returns a rule that reports queries that are longer than
+// maxLen bytes.
+func GuardMaxLength(maxLen int) GuardRule {
+	return GuardRule{
+		Name: "max_length",
+		Check: func(stmt *GuardStatement) string {
+			// the query is only checked once, for its first
+			// statement
+			if stmt.Index != 0 || len(stmt.Query) <= maxLen {
+				return ""
+			}
+
+			return fmt.Sprintf("query length %d exceeds %d bytes", len(stmt.Query), maxLen)
+		},
+	}
+}
+
+// check checks the statements of query and reports its violations.
+// It returns a *GuardError if the query violates a rule and the Guard
+// rejects queries.
+func (g *Guard) check(ctx context.Context, op SQLOp, query string, parsed *parsedQuery, span Span) error {
+	var violations []*GuardViolation
+
+	for i, parsedStmt := range parsed.statements {
+		stmt := GuardStatement{
+			Op:        op,
+			Query:     query,
+			Index:     i,
+			Statement: parsedStmt.text,
+			Tokens:    parsedStmt.tokens,
+			Operation: parsedStmt.analysis.Operation,
+			Tables:    parsedStmt.analysis.Tables,
+		}
+
+		for _, rule := range g.rules {
+			msg := rule.Check(&stmt)
+			if msg == "" {
+				continue
+			}
+
+			violations = append(violations, &GuardViolation{
+				Rule:      rule.Name,
+				Message:   msg,
+				Op:        op,
+				Statement: parsedStmt.text,
+			})
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(violations))
+	seen := make(map[string]struct{}, len(violations))
+	for _, v := range violations {
+		if _, exist := seen[v.Rule]; !exist {
+			seen[v.Rule] = struct{}{}
+			names = append(names, v.Rule)
+		}
+
+		if g.callback != nil {
+			g.callback(ctx, v)
+		}
+	}
+
+	span.SetTag(DBGuardViolationsTagKey, strings.Join(names, ","))
+
+	if g.reject {
+		return &GuardError{Violations: violations}
+	}
+
+	return nil
+}
+
+func isKeywordToken(tok querynorm.Token, keywords ...string) bool {
+	if tok.Kind != querynorm.TokenWord {
+		return false
+	}
+
+	upper := strings.ToUpper(tok.Text)
+	for _, kw := range keywords {
+		if upper == kw {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasTopLevelKeyword returns true if one of the keywords appears outside
+// of parentheses in tokens.
+func hasTopLevelKeyword(tokens []querynorm.Token, keywords ...string) bool {
+	depth := 0
+
+	for _, tok := range tokens {
+		if tok.Kind == querynorm.TokenPunctuation {
+			switch tok.Text {
+			case "(":
+				depth++
+			case ")":
+				depth--
+			}
+
+			continue
+		}
+
+		if depth == 0 && isKeywordToken(tok, keywords...) {
+			return true
+		}
+	}
+
+	return false
+}
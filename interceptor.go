@@ -5,30 +5,70 @@ package sqltracing
 import (
 	"context"
 	"database/sql/driver"
+	"errors"
 	"io"
+	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/simplesurance/sqlmw"
+	"github.com/simplesurance/sqltracing/querynorm"
 )
 
 // Interceptor records traces for database operations.
 // It implements the sqlmw.Interceptor interfaces.
 type Interceptor struct {
-	excludedOps map[SQLOp]struct{}
-	tracer      Tracer
+	excludedOps         map[SQLOp]struct{}
+	tracer              Tracer
+	recordRowsAffected  bool
+	recordLastInsertID  bool
+	maxStatementLength  int
+	callSiteResolver    *callSiteResolver
+	recordColumnNames   bool
+	queryNameRegexps    []*regexp.Regexp
+	queryNameAsSpanName bool
+	metrics             Metrics
+	errorClassifier     func(error) string
+	poolStats           *PoolStatsCollector
+	registry            *Registry
+	queryStats          *QueryStats
+	nPlusOne            *NPlusOneDetector
+	commenter           *SQLCommenter
+	onConnect           OnConnectFunc
+	timeouts            timeouts
+	guard               *Guard
+	explainer           *Explainer
+	connLifetimeSpans   bool
+
+	dialect               querynorm.Dialect
+	tagFingerprint        bool
+	metricsFingerprint    bool
+	tagOperationAndTables bool
+	tagBatches            bool
+	fingerprintCacheSize  int
+	queryCache            *queryCache
 }
 
 // NewInterceptor returns a new interceptor that records traces for database
 // operations.
+// The optional driver.Rows interfaces are only available when the driver
+// is wrapped via WrapDriver, when the Interceptor is passed directly to
+// sqlmw they are hidden.
 func NewInterceptor(tracer Tracer, opts ...Opt) *Interceptor {
 	icp := Interceptor{
-		excludedOps: map[SQLOp]struct{}{},
-		tracer:      tracer,
+		excludedOps:          map[SQLOp]struct{}{},
+		tracer:               tracer,
+		errorClassifier:      ClassifyError,
+		dialect:              querynorm.DialectPostgres,
+		fingerprintCacheSize: DefaultFingerprintCacheSize,
 	}
 
 	for _, opt := range opts {
 		opt(&icp)
 	}
 
+	icp.queryCache = newQueryCache(icp.dialect, icp.fingerprintCacheSize, icp.queryNameRegexps, icp.guard != nil)
+
 	return &icp
 }
 
@@ -36,13 +76,19 @@ func NewInterceptor(tracer Tracer, opts ...Opt) *Interceptor {
 // for it's operations.
 // Compatible tracer implementations can be found in the package
 // sqltracing/tracing/.
+// Rows support multiple result sets and column types if the wrapped driver
+// does, advancing to the next result set is recorded as
+// OpSQLRowsNextResultSet operation.
 func WrapDriver(driver driver.Driver, tracer Tracer, opts ...Opt) driver.Driver {
 	icp := NewInterceptor(tracer, opts...)
 
-	return sqlmw.WrapDriver(
-		driver,
-		icp,
-	)
+	return &outerDriver{
+		Driver: sqlmw.WrapDriver(
+			driver,
+			icp,
+		),
+		icp: icp,
+	}
 }
 
 func (t *Interceptor) ConnBeginTx(ctx context.Context, con driver.ConnBeginTx, txOpts driver.TxOptions) (_ driver.Tx, err error) {
@@ -52,15 +98,24 @@ func (t *Interceptor) ConnBeginTx(ctx context.Context, con driver.ConnBeginTx, t
 		return con.BeginTx(ctx, txOpts)
 	}
 
-	span, ctx := t.tracer.StartSpan(ctx, op.String())
+	span, finishFn, ctx := t.startSpan(ctx, op, "")
+	span.SetTags(map[string]string{
+		DBTxIsolationTagKey: isolationLevelName(txOpts.Isolation),
+		DBTxReadOnlyTagKey:  strconv.FormatBool(txOpts.ReadOnly),
+	})
+
+	ctx, connRef := withConnRef(ctx)
+	ctx, timeout := t.applyTimeout(ctx, op, "", span)
+	finishFn = timeout.finishFunc(finishFn)
 
 	tx, err := con.BeginTx(ctx, txOpts)
+	connRef.conn.tagSpan(span)
 	if err != nil {
-		spanFinishFunc(span)(err)
+		finishFn(err)
 		return nil, err
 	}
 
-	return newTracedTx(ctx, spanFinishFunc(span), tx), nil
+	return newTracedTx(ctx, span, finishFn, tx, connRef.conn), nil
 }
 
 func (t *Interceptor) ConnPrepareContext(ctx context.Context, con driver.ConnPrepareContext, query string) (_ driver.Stmt, err error) {
@@ -70,41 +125,80 @@ func (t *Interceptor) ConnPrepareContext(ctx context.Context, con driver.ConnPre
 		return con.PrepareContext(ctx, query)
 	}
 
-	span, ctx := t.tracer.StartSpan(ctx, op.String())
-	span.SetTag(DBStatementTagKey, query)
+	span, finishFn, ctx := t.startSpan(ctx, op, query)
+	ctx, connRef := withConnRef(ctx)
+
+	if err := t.checkGuard(ctx, op, query, span); err != nil {
+		finishFn(err)
+		return nil, err
+	}
+
+	// the timeout only applies to preparing the statement, ctx is
+	// used for executions of the statement
+	callCtx, timeout := t.applyTimeout(ctx, op, query, span)
 
-	stmt, err := con.PrepareContext(ctx, query)
+	stmt, err := con.PrepareContext(callCtx, t.commentQuery(ctx, op, span, query, false))
+	timeout.finish()
+	connRef.conn.tagSpan(span)
 	if err != nil {
-		spanFinishFunc(span)(err)
+		finishFn(err)
 		return nil, err
 	}
 
-	return newTracedStmt(ctx, spanFinishFunc(span), stmt), nil
+	return newTracedStmt(ctx, finishFn, stmt, connRef.conn, query), nil
 }
 
 func (t *Interceptor) ConnPing(ctx context.Context, con driver.Pinger) (err error) {
-	var deferFn func(err error)
+	span, deferFn, ctx := t.startSpan(ctx, OpSQLPing, "")
+	defer func() { deferFn(err) }()
 
-	deferFn, ctx = t.startSpan(ctx, OpSQLPing, "")
-	defer deferFn(err)
+	ctx, connRef := withConnRef(ctx)
+	callCtx, timeout := t.applyTimeout(ctx, OpSQLPing, "", span)
 
-	return con.Ping(ctx)
+	err = con.Ping(callCtx)
+	timeout.finish()
+	connRef.conn.tagSpan(span)
+
+	return err
 }
 
 func (t *Interceptor) ConnExecContext(ctx context.Context, con driver.ExecerContext, query string, args []driver.NamedValue) (_ driver.Result, err error) {
-	var deferFn func(err error)
+	span, finishFn, ctx := t.startSpan(ctx, OpSQLConnExec, query)
+	ctx, connRef := withConnRef(ctx)
+
+	if err := t.checkGuard(ctx, OpSQLConnExec, query, span); err != nil {
+		finishFn(err)
+		return nil, err
+	}
 
-	deferFn, ctx = t.startSpan(ctx, OpSQLConnExec, query)
-	defer deferFn(err)
+	callCtx, timeout := t.applyTimeout(ctx, OpSQLConnExec, query, span)
+	finishFn = t.startExplain(OpSQLConnExec, query, args, span).finishFunc(finishFn)
 
-	return con.ExecContext(ctx, query, args)
+	res, err := con.ExecContext(callCtx, t.commentQuery(ctx, OpSQLConnExec, span, query, len(args) > 0), args)
+	timeout.finish()
+	connRef.conn.countStatement()
+	connRef.conn.tagSpan(span)
+	if err != nil {
+		finishFn(err)
+		return nil, err
+	}
+
+	t.handleResult(ctx, span, res)
+	finishFn(nil)
+
+	return res, nil
 }
 
 func (t *Interceptor) ConnQueryContext(ctx context.Context, con driver.QueryerContext, query string, args []driver.NamedValue) (_ driver.Rows, err error) {
 	const op = OpSQLConnQuery
 
+	rowsRef := rowsRefFromContext(ctx)
+
 	if t.opIsExcluded(op) {
+		ctx, connRef := withConnRef(ctx)
+
 		rows, err := con.QueryContext(ctx, query, args)
+		connRef.conn.countStatement()
 		if err != nil {
 			return nil, err
 		}
@@ -112,34 +206,140 @@ func (t *Interceptor) ConnQueryContext(ctx context.Context, con driver.QueryerCo
 		// rows are wrapped to have access to the parent span of the
 		// current operation, to record spans for other rows Ops that
 		// are not excluded
-		return newTracedRows(ctx, func(_ error) {}, rows), nil
+		return rowsRef.set(newTracedRows(ctx, noopSpan{}, func(_ error) {}, rows, time.Time{})), nil
+	}
+
+	span, finishFn, ctx := t.startSpan(ctx, op, query)
+	ctx, connRef := withConnRef(ctx)
+
+	if err := t.checkGuard(ctx, op, query, span); err != nil {
+		finishFn(err)
+		return nil, err
 	}
 
-	span, ctx := t.tracer.StartSpan(ctx, op.String())
-	span.SetTag(DBStatementTagKey, query)
+	// the rows are read with callCtx, the timeout is finished when they
+	// are closed
+	callCtx, timeout := t.applyTimeout(ctx, op, query, span)
+	finishFn = timeout.finishFunc(finishFn)
+	finishFn = t.startExplain(op, query, args, span).finishFunc(finishFn)
 
-	rows, err := con.QueryContext(ctx, query, args)
+	started := time.Now()
+	rows, err := con.QueryContext(callCtx, t.commentQuery(ctx, op, span, query, len(args) > 0), args)
+	connRef.conn.countStatement()
+	connRef.conn.tagSpan(span)
 	if err != nil {
-		spanFinishFunc(span)(err)
+		finishFn(err)
 		return nil, err
 	}
 
-	return newTracedRows(ctx, spanFinishFunc(span), rows), nil
+	return rowsRef.set(newTracedRows(ctx, span, finishFn, rows, started)), nil
 }
 
 func (t *Interceptor) ConnectorConnect(ctx context.Context, connector driver.Connector) (_ driver.Conn, err error) {
-	var deferFn func(err error)
+	span, deferFn, ctx := t.startSpan(ctx, OpSQLConnect, "")
+	defer func() { deferFn(err) }()
+
+	// the timeout also applies to the OnConnectFunc
+	ctx, timeout := t.applyTimeout(ctx, OpSQLConnect, "", span)
+	defer timeout.finish()
+
+	conn, err := connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	deferFn, ctx = t.startSpan(ctx, OpSQLConnect, "")
-	defer deferFn(err)
+	tracedConn := newTracedConn(conn, t)
 
-	return connector.Connect(ctx)
+	if t.onConnect != nil {
+		if err := t.runOnConnect(ctx, tracedConn); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	if t.connLifetimeSpans {
+		tracedConn.startLifetimeSpan(t.tracer)
+	}
+
+	// makes the connection available to outerConn
+	tracedConn.storeInRef(ctx)
+
+	return tracedConn, nil
 }
 
+// connResetSession resets the session of the connection and records an
+// OpSQLResetSession span, if WithConnLifetimeSpans was passed.
+// ResetSession is called every time a pooled connection is reused, the
+// span is not recorded by default.
+func (t *Interceptor) connResetSession(ctx context.Context, conn *tracedConn, resetter driver.SessionResetter) (err error) {
+	if !t.connLifetimeSpans {
+		err = resetter.ResetSession(ctx)
+		if errors.Is(err, driver.ErrBadConn) {
+			conn.markInvalid(ConnInvalidReasonResetSession)
+		}
+
+		return err
+	}
+
+	span, deferFn, ctx := t.startSpan(ctx, OpSQLResetSession, "")
+	defer func() { deferFn(err) }()
+
+	conn.tagSpan(span)
+
+	err = resetter.ResetSession(ctx)
+	if errors.Is(err, driver.ErrBadConn) {
+		conn.markInvalid(ConnInvalidReasonResetSession)
+	}
+
+	return err
+}
+
+// connClose closes the connection and records an OpSQLConnClose span, if
+// WithConnLifetimeSpans was passed or the connection is invalid.
+// The OpSQLConnLifetime span of the connection is finished.
+func (t *Interceptor) connClose(conn *tracedConn) (err error) {
+	if !t.connLifetimeSpans && conn.invalidReason == "" {
+		return conn.Conn.Close()
+	}
+
+	ctx := context.Background()
+	if conn.lifetimeCtx != nil {
+		ctx = conn.lifetimeCtx
+	}
+
+	span, deferFn, _ := t.startSpan(ctx, OpSQLConnClose, "")
+	defer func() { deferFn(err) }()
+
+	conn.tagSpan(span)
+
+	if conn.invalidReason != "" {
+		span.SetTags(map[string]string{
+			DBConnInvalidTagKey:       "true",
+			DBConnInvalidReasonTagKey: conn.invalidReason,
+		})
+
+		// without a lifetime span the event was not recorded yet
+		if conn.lifetimeSpan == nil {
+			recordConnInvalid(span, conn.invalidReason)
+		}
+	}
+
+	err = conn.Conn.Close()
+	conn.finishLifetimeSpan()
+
+	return err
+}
+
+// ResultLastInsertId returns the last insert id of res.
+// The exec operation that returned res is already finished, the id is
+// recorded before via WithLastInsertID.
 func (t *Interceptor) ResultLastInsertId(res driver.Result) (int64, error) {
 	return res.LastInsertId()
 }
 
+// ResultRowsAffected returns the number of rows affected by res.
+// The exec operation that returned res is already finished, the number is
+// recorded before via WithRowsAffected.
 func (t *Interceptor) ResultRowsAffected(res driver.Result) (int64, error) {
 	return res.RowsAffected()
 }
@@ -147,69 +347,125 @@ func (t *Interceptor) ResultRowsAffected(res driver.Result) (int64, error) {
 func (t *Interceptor) RowsNext(rows driver.Rows, dest []driver.Value) (err error) {
 	var ctx context.Context
 
-	if tracedRows, ok := rows.(*tracedRows); ok {
+	tracedRows, isTraced := rows.(*tracedRows)
+	if isTraced {
 		ctx = tracedRows.ctx
+		tracedRows.recordColumns(t.recordColumnNames)
 	} else {
 		ctx = context.Background()
 	}
 
-	deferFn, _ := t.startSpan(ctx, OpSQLRowsNext, "", io.EOF)
-	defer deferFn(err)
+	_, deferFn, _ := t.startSpan(ctx, OpSQLRowsNext, "", io.EOF)
+	defer func() { deferFn(err) }()
+
+	start := time.Now()
+	err = rows.Next(dest)
+	if isTraced {
+		tracedRows.observeNext(start, err)
+	}
+
+	if err == nil {
+		addQueryStatsRows(ctx, 1)
+	}
 
-	return rows.Next(dest)
+	return err
 }
 
 func (t *Interceptor) RowsClose(rows driver.Rows) (err error) {
 	const op = OpSQLRowsClose
 
 	if tracedRows, ok := rows.(*tracedRows); ok {
-		deferFn, _ := t.startSpan(tracedRows.ctx, op, "")
-		defer deferFn(err)
+		_, deferFn, _ := t.startSpan(tracedRows.ctx, op, "")
+		defer func() { deferFn(err) }()
 
 		// nil instead of err is passed because it finishes the operation that
 		// created the Stmt, which succeeded
 		defer tracedRows.parentSpanFinishFn(nil)
 
+		tracedRows.recordTimings()
+
 		return rows.Close()
 	}
 
-	deferFn, _ := t.startSpan(context.Background(), op, "")
-	defer deferFn(err)
+	_, deferFn, _ := t.startSpan(context.Background(), op, "")
+	defer func() { deferFn(err) }()
 
 	return rows.Close()
 }
 
+// rowsNextResultSet advances rows to their next result set and records it
+// as OpSQLRowsNextResultSet operation.
+// io.EOF, that is returned when no further result set exists, is not
+// recorded as error.
+func (t *Interceptor) rowsNextResultSet(rows *tracedRows, nrs driver.RowsNextResultSet) (err error) {
+	_, deferFn, _ := t.startSpan(rows.ctx, OpSQLRowsNextResultSet, "", io.EOF)
+	defer func() { deferFn(err) }()
+
+	return nrs.NextResultSet()
+}
+
 func (t *Interceptor) StmtExecContext(ctx context.Context, stmt *sqlmw.Stmt, args []driver.NamedValue) (_ driver.Result, err error) {
+	var conn *tracedConn
+	var query string
 	if tracedStmt, ok := stmt.Parent().(*tracedStmt); ok {
-		ctx = tracedStmt.ctx
+		ctx = tracedStmt.stmtCtx(ctx)
+		conn = tracedStmt.conn
+		query = tracedStmt.query
+	}
+
+	span, finishFn, ctx := t.startSpan(ctx, OpSQLStmtExec, query)
+	setActiveOpConn(ctx, conn)
+	callCtx, timeout := t.applyTimeout(ctx, OpSQLStmtExec, query, span)
+	finishFn = t.startExplain(OpSQLStmtExec, query, args, span).finishFunc(finishFn)
+
+	res, err := stmt.ExecContext(callCtx, args)
+	timeout.finish()
+	conn.countOp()
+	conn.countStatement()
+	if err != nil {
+		finishFn(err)
+		return nil, err
 	}
 
-	deferFn, ctx := t.startSpan(ctx, OpSQLStmtExec, "")
-	defer deferFn(err)
+	t.handleResult(ctx, span, res)
+	finishFn(nil)
 
-	return stmt.ExecContext(ctx, args)
+	return res, nil
 }
 
 func (t *Interceptor) StmtQueryContext(ctx context.Context, stmt *sqlmw.Stmt, args []driver.NamedValue) (rows driver.Rows, err error) {
+	rowsRef := rowsRefFromContext(ctx)
+
+	var conn *tracedConn
+	var query string
 	if tracedStmt, ok := stmt.Parent().(*tracedStmt); ok {
-		ctx = tracedStmt.ctx
+		ctx = tracedStmt.stmtCtx(ctx)
+		conn = tracedStmt.conn
+		query = tracedStmt.query
 	}
 
-	deferFn, ctx := t.startSpan(ctx, OpSQLStmtQuery, "")
+	span, deferFn, ctx := t.startSpan(ctx, OpSQLStmtQuery, query)
+	setActiveOpConn(ctx, conn)
+	callCtx, timeout := t.applyTimeout(ctx, OpSQLStmtQuery, query, span)
+	deferFn = timeout.finishFunc(deferFn)
+	deferFn = t.startExplain(OpSQLStmtQuery, query, args, span).finishFunc(deferFn)
 
-	rows, err = stmt.QueryContext(ctx, args)
+	started := time.Now()
+	rows, err = stmt.QueryContext(callCtx, args)
+	conn.countOp()
+	conn.countStatement()
 	if err != nil {
 		deferFn(err)
 		return nil, err
 	}
 
-	return newTracedRows(ctx, deferFn, rows), nil
+	return rowsRef.set(newTracedRows(ctx, span, deferFn, rows, started)), nil
 }
 
 func (t *Interceptor) StmtClose(stmt *sqlmw.Stmt) (err error) {
 	if tracedStmt, ok := stmt.Parent().(*tracedStmt); ok {
-		deferFn, _ := t.startSpan(tracedStmt.ctx, OpSQLStmtClose, "")
-		defer deferFn(err)
+		_, deferFn, _ := t.startSpan(tracedStmt.ctx, OpSQLStmtClose, "")
+		defer func() { deferFn(err) }()
 
 		// nil instead of err is passed because it finishes the operation that
 		// created the Stmt, which succeeded
@@ -218,8 +474,8 @@ func (t *Interceptor) StmtClose(stmt *sqlmw.Stmt) (err error) {
 		return stmt.Close()
 	}
 
-	deferFn, _ := t.startSpan(context.Background(), OpSQLStmtClose, "")
-	defer deferFn(nil)
+	_, deferFn, _ := t.startSpan(context.Background(), OpSQLStmtClose, "")
+	defer func() { deferFn(err) }()
 
 	return stmt.Close()
 }
@@ -228,15 +484,22 @@ func (t *Interceptor) TxCommit(tx driver.Tx) (err error) {
 	const op = OpSQLTxCommit
 
 	if tracedTx, ok := tx.(*tracedTx); ok {
-		deferFn, _ := t.startSpan(tracedTx.ctx, op, "")
-		defer deferFn(err)
-		tracedTx.parentSpanFinishFn(nil)
+		_, deferFn, _ := t.startSpan(tracedTx.ctx, op, "")
+		defer func() { deferFn(err) }()
+
+		err = tx.Commit()
+		if err != nil {
+			tracedTx.finish(TxOutcomeCommitFailed)
+			return err
+		}
 
-		return tx.Commit()
+		tracedTx.finish(TxOutcomeCommit)
+
+		return nil
 	}
 
-	deferFn, _ := t.startSpan(context.Background(), op, "")
-	defer deferFn(err)
+	_, deferFn, _ := t.startSpan(context.Background(), op, "")
+	defer func() { deferFn(err) }()
 
 	return tx.Commit()
 }
@@ -245,19 +508,82 @@ func (t *Interceptor) TxRollback(tx driver.Tx) (err error) {
 	const op = OpSQLTxRollback
 
 	if tracedTx, ok := tx.(*tracedTx); ok {
-		deferFn, _ := t.startSpan(tracedTx.ctx, op, "")
-		defer deferFn(err)
-		tracedTx.parentSpanFinishFn(nil)
+		_, deferFn, _ := t.startSpan(tracedTx.ctx, op, "")
+		defer func() { deferFn(err) }()
+
+		err = tx.Rollback()
+		tracedTx.finish(TxOutcomeRollback)
 
-		return tx.Rollback()
+		return err
 	}
 
-	deferFn, _ := t.startSpan(context.Background(), op, "")
-	defer deferFn(err)
+	_, deferFn, _ := t.startSpan(context.Background(), op, "")
+	defer func() { deferFn(err) }()
 
 	return tx.Rollback()
 }
 
+// handleResult records the result of an exec operation before its span is
+// finished.
+// The number of affected rows is recorded on the span, if enabled via
+// WithRowsAffected, and in the QueryStats. The last insert id is recorded
+// on the span, if enabled via WithLastInsertID.
+// Errors are ignored, drivers are not required to support RowsAffected()
+// and LastInsertId().
+func (t *Interceptor) handleResult(ctx context.Context, span Span, res driver.Result) {
+	if res == nil {
+		return
+	}
+
+	if t.recordRowsAffected || t.queryStats != nil {
+		if cnt, err := res.RowsAffected(); err == nil {
+			addQueryStatsRows(ctx, cnt)
+
+			if t.recordRowsAffected {
+				span.SetTag(DBRowsAffectedTagKey, strconv.FormatInt(cnt, 10))
+			}
+		}
+	}
+
+	if t.recordLastInsertID {
+		if id, err := res.LastInsertId(); err == nil {
+			span.SetTag(DBLastInsertIDTagKey, strconv.FormatInt(id, 10))
+		}
+	}
+}
+
+// commentQuery appends a sqlcommenter comment to query, if enabled via
+// WithSQLCommenter.
+// The query is not commented for excluded operations.
+func (t *Interceptor) commentQuery(ctx context.Context, op SQLOp, span Span, query string, hasArgs bool) string {
+	if t.commenter == nil || t.opIsExcluded(op) {
+		return query
+	}
+
+	return t.commenter.comment(ctx, op, span, query, hasArgs)
+}
+
+// checkGuard checks query with the Guard, if enabled via WithGuard.
+// The query is not checked for excluded operations.
+func (t *Interceptor) checkGuard(ctx context.Context, op SQLOp, query string, span Span) error {
+	if t.guard == nil || t.opIsExcluded(op) {
+		return nil
+	}
+
+	return t.guard.check(ctx, op, query, t.queryCache.get(query), span)
+}
+
+// startExplain starts explaining the query if it is slow, if enabled via
+// WithExplainer.
+// The query is not explained for excluded operations.
+func (t *Interceptor) startExplain(op SQLOp, query string, args []driver.NamedValue, span Span) *explainOp {
+	if t.explainer == nil || query == "" || t.opIsExcluded(op) {
+		return nil
+	}
+
+	return t.explainer.start(t.dialect, query, t.queryCache.get(query), args, span)
+}
+
 func (t *Interceptor) opIsExcluded(op SQLOp) bool {
 	_, exist := t.excludedOps[op]
 	return exist
@@ -0,0 +1,99 @@
+package sqltracing
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"time"
+)
+
+// Defines the values of OpObservation.Outcome.
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+// Defines the error classes that are returned by ClassifyError.
+const (
+	ErrorClassCanceled = "canceled"
+	ErrorClassTimeout  = "timeout"
+	ErrorClassBadConn  = "bad_conn"
+	ErrorClassNoRows   = "no_rows"
+	ErrorClassOther    = "other"
+)
+
+// Metrics defines the required methods of a metrics implementation.
+// Compatible implementations can be found in the package
+// sqltracing/metrics/.
+type Metrics interface {
+	// OpStarted is called when a database operation starts.
+	OpStarted(ctx context.Context, op SQLOp)
+	// OpFinished is called when an operation that was reported via
+	// OpStarted finished. ctx is the context that contains the span of
+	// the operation.
+	OpFinished(ctx context.Context, obs *OpObservation)
+}
+
+// OpObservation describes a finished database operation.
+type OpObservation struct {
+	Op SQLOp
+	// QueryName is the name of the query, it is empty if the operation
+	// has no name.
+	QueryName string
+	// Fingerprint is the fingerprint of the query as hex string, like in
+	// the DBQueryFingerprintTagKey tag. It is only set for operations
+	// with a query, if WithMetricsFingerprint was passed to the
+	// Interceptor.
+	Fingerprint string
+	Duration    time.Duration
+	// Outcome is OutcomeSuccess or OutcomeError.
+	Outcome string
+	// ErrorClass is the class of the error that was returned by the
+	// operation, it is empty when the operation succeeded.
+	ErrorClass string
+}
+
+// ClassifyError returns the error class of err.
+// It is the default error classifier of the Interceptor.
+func ClassifyError(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	case errors.Is(err, driver.ErrBadConn):
+		return ErrorClassBadConn
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrorClassNoRows
+	default:
+		return ErrorClassOther
+	}
+}
+
+// observeFunc reports the start of an operation to the metrics
+// implementation and returns a function that reports it as finished.
+func (d *Interceptor) observeFunc(ctx context.Context, op SQLOp, queryName, fingerprint string, whitelistedErr []error) func(err error) {
+	startTime := time.Now()
+
+	d.metrics.OpStarted(ctx, op)
+
+	return func(err error) {
+		obs := OpObservation{
+			Op:          op,
+			QueryName:   queryName,
+			Fingerprint: fingerprint,
+			Duration:    time.Since(startTime),
+			Outcome:     OutcomeSuccess,
+		}
+
+		if err != nil && !errisOneOf(err, whitelistedErr) {
+			obs.Outcome = OutcomeError
+			obs.ErrorClass = d.errorClassifier(err)
+		}
+
+		d.metrics.OpFinished(ctx, &obs)
+	}
+}
@@ -0,0 +1,327 @@
+// Package inprocess provides a dependency-free Metrics implementation that
+// is compatible with the sqltracing.Metrics interface.
+// It aggregates the metrics in memory, they can be read via Snapshot().
+package inprocess
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/simplesurance/sqltracing"
+)
+
+// DefaultBuckets are the default upper bounds of the latency histogram
+// buckets.
+var DefaultBuckets = []time.Duration{
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Labels identifies a metric series.
+type Labels struct {
+	Op         sqltracing.SQLOp
+	Outcome    string
+	ErrorClass string
+	// QueryName is only set when WithQueryNameLabel is enabled.
+	QueryName string
+	// Fingerprint is only set when WithFingerprintLabel is enabled.
+	Fingerprint string
+}
+
+// Metrics records counters and latency histograms for database operations
+// in memory.
+type Metrics struct {
+	buckets          []time.Duration
+	queryNameLabel   bool
+	fingerprintLabel bool
+	traceIDFn        func(context.Context) string
+
+	mu       sync.Mutex
+	series   map[Labels]*series
+	inFlight map[sqltracing.SQLOp]int64
+	pools    map[string]*sqltracing.PoolStats
+}
+
+type series struct {
+	count uint64
+	sum   time.Duration
+	// bucketCounts are the non-cumulative number of observations per
+	// bucket, the last element counts observations that are bigger than
+	// the biggest bucket.
+	bucketCounts []uint64
+	// exemplars contains the last exemplar per bucket, including the
+	// overflow bucket, nil elements mean no exemplar was recorded.
+	exemplars []*Exemplar
+}
+
+// Exemplar is an observation that references the trace of the operation.
+type Exemplar struct {
+	TraceID   string
+	Value     time.Duration
+	Timestamp time.Time
+}
+
+// Opt is a type for options that can be passed to New.
+type Opt func(*Metrics)
+
+// WithBuckets is an option for New() to set the upper bounds of the latency
+// histogram buckets. The buckets are sorted ascending.
+func WithBuckets(buckets ...time.Duration) Opt {
+	return func(m *Metrics) {
+		m.buckets = make([]time.Duration, len(buckets))
+		copy(m.buckets, buckets)
+		sort.Slice(m.buckets, func(i, j int) bool { return m.buckets[i] < m.buckets[j] })
+	}
+}
+
+// WithQueryNameLabel is an option for New() to record separate series per
+// query name.
+// It should only be enabled if the number of distinct query names is small.
+func WithQueryNameLabel() Opt {
+	return func(m *Metrics) {
+		m.queryNameLabel = true
+	}
+}
+
+// WithFingerprintLabel is an option for New() to record separate series
+// per query fingerprint.
+// Fingerprints are only reported by Interceptors that were created with
+// sqltracing.WithMetricsFingerprint.
+// It should only be enabled if the number of distinct queries is small.
+func WithFingerprintLabel() Opt {
+	return func(m *Metrics) {
+		m.fingerprintLabel = true
+	}
+}
+
+// WithExemplars is an option for New() to record the last observation per
+// histogram bucket as exemplar.
+// traceIDFn is called with the context of the operation, it returns the ID
+// of the trace that contains the span of the operation or an empty string if
+// it is not part of a trace.
+func WithExemplars(traceIDFn func(ctx context.Context) string) Opt {
+	return func(m *Metrics) {
+		m.traceIDFn = traceIDFn
+	}
+}
+
+// New returns a new in-memory metrics recorder.
+// When no options are passed, DefaultBuckets are used as histogram buckets
+// and query names and fingerprints are not recorded.
+func New(opts ...Opt) *Metrics {
+	m := Metrics{
+		buckets:  DefaultBuckets,
+		series:   map[Labels]*series{},
+		inFlight: map[sqltracing.SQLOp]int64{},
+		pools:    map[string]*sqltracing.PoolStats{},
+	}
+
+	for _, opt := range opts {
+		opt(&m)
+	}
+
+	return &m
+}
+
+// OpStarted implements sqltracing.Metrics.
+func (m *Metrics) OpStarted(_ context.Context, op sqltracing.SQLOp) {
+	m.mu.Lock()
+	m.inFlight[op]++
+	m.mu.Unlock()
+}
+
+// OpFinished implements sqltracing.Metrics.
+func (m *Metrics) OpFinished(ctx context.Context, obs *sqltracing.OpObservation) {
+	labels := Labels{
+		Op:         obs.Op,
+		Outcome:    obs.Outcome,
+		ErrorClass: obs.ErrorClass,
+	}
+
+	if m.queryNameLabel {
+		labels.QueryName = obs.QueryName
+	}
+
+	if m.fingerprintLabel {
+		labels.Fingerprint = obs.Fingerprint
+	}
+
+	bucketIdx := sort.Search(len(m.buckets), func(i int) bool {
+		return obs.Duration <= m.buckets[i]
+	})
+
+	var exemplar *Exemplar
+	if m.traceIDFn != nil {
+		if traceID := m.traceIDFn(ctx); traceID != "" {
+			exemplar = &Exemplar{
+				TraceID:   traceID,
+				Value:     obs.Duration,
+				Timestamp: time.Now(),
+			}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight[obs.Op]--
+
+	s, exist := m.series[labels]
+	if !exist {
+		s = &series{
+			bucketCounts: make([]uint64, len(m.buckets)+1),
+			exemplars:    make([]*Exemplar, len(m.buckets)+1),
+		}
+		m.series[labels] = s
+	}
+
+	s.count++
+	s.sum += obs.Duration
+	s.bucketCounts[bucketIdx]++
+
+	if exemplar != nil {
+		s.exemplars[bucketIdx] = exemplar
+	}
+}
+
+// PoolStats implements sqltracing.PoolMetrics.
+// The last reported statistics per pool name are kept.
+func (m *Metrics) PoolStats(stats *sqltracing.PoolStats) {
+	cpy := *stats
+
+	m.mu.Lock()
+	m.pools[stats.Name] = &cpy
+	m.mu.Unlock()
+}
+
+// Snapshot is a point-in-time copy of the recorded metrics.
+type Snapshot struct {
+	// Buckets are the upper bounds of the histogram buckets.
+	Buckets []time.Duration
+	// Series contains one element per distinct label set, sorted by
+	// labels.
+	Series []*SeriesSnapshot
+	// InFlight is the number of operations that are currently running
+	// per SQLOp.
+	InFlight map[sqltracing.SQLOp]int64
+	// Pools contains the last reported connection pool statistics per
+	// pool, sorted by name.
+	Pools []*sqltracing.PoolStats
+}
+
+// SeriesSnapshot contains the values of a metric series.
+type SeriesSnapshot struct {
+	Labels
+	// Count is the number of observed operations.
+	Count uint64
+	// Sum is the total duration of all observed operations.
+	Sum time.Duration
+	// BucketCounts contains the cumulative number of observations per
+	// bucket: BucketCounts[i] is the number of operations that took at most
+	// Snapshot.Buckets[i].
+	BucketCounts []uint64
+	// Exemplars contains the last exemplar per bucket, the last element is
+	// the exemplar of the implicit +Inf bucket.
+	// Elements are nil if no exemplar was recorded for the bucket.
+	Exemplars []*Exemplar
+}
+
+// Snapshot returns a copy of the current metrics.
+func (m *Metrics) Snapshot() *Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := Snapshot{
+		Buckets:  make([]time.Duration, len(m.buckets)),
+		Series:   make([]*SeriesSnapshot, 0, len(m.series)),
+		InFlight: make(map[sqltracing.SQLOp]int64, len(m.inFlight)),
+	}
+
+	copy(result.Buckets, m.buckets)
+
+	for op, cnt := range m.inFlight {
+		result.InFlight[op] = cnt
+	}
+
+	for _, stats := range m.pools {
+		cpy := *stats
+		result.Pools = append(result.Pools, &cpy)
+	}
+
+	sort.Slice(result.Pools, func(i, j int) bool {
+		return result.Pools[i].Name < result.Pools[j].Name
+	})
+
+	for labels, s := range m.series {
+		ss := SeriesSnapshot{
+			Labels:       labels,
+			Count:        s.count,
+			Sum:          s.sum,
+			BucketCounts: make([]uint64, len(m.buckets)),
+			Exemplars:    make([]*Exemplar, len(s.exemplars)),
+		}
+
+		copy(ss.Exemplars, s.exemplars)
+
+		var cumulative uint64
+		for i := range m.buckets {
+			cumulative += s.bucketCounts[i]
+			ss.BucketCounts[i] = cumulative
+		}
+
+		result.Series = append(result.Series, &ss)
+	}
+
+	sort.Slice(result.Series, func(i, j int) bool {
+		return result.Series[i].Labels.less(&result.Series[j].Labels)
+	})
+
+	return &result
+}
+
+// Reset removes all recorded series.
+// In-flight counters are kept, to not become negative when running
+// operations finish.
+func (m *Metrics) Reset() {
+	m.mu.Lock()
+	m.series = map[Labels]*series{}
+	m.mu.Unlock()
+}
+
+func (l *Labels) less(o *Labels) bool {
+	if l.Op != o.Op {
+		return l.Op < o.Op
+	}
+
+	if l.Outcome != o.Outcome {
+		return l.Outcome < o.Outcome
+	}
+
+	if l.ErrorClass != o.ErrorClass {
+		return l.ErrorClass < o.ErrorClass
+	}
+
+	if l.QueryName != o.QueryName {
+		return l.QueryName < o.QueryName
+	}
+
+	return l.Fingerprint < o.Fingerprint
+}
+
+var (
+	_ sqltracing.Metrics     = &Metrics{}
+	_ sqltracing.PoolMetrics = &Metrics{}
+)
@@ -0,0 +1,85 @@
+package inprocess
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/simplesurance/sqltracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	m := New(WithBuckets(10*time.Millisecond, time.Millisecond))
+
+	for _, d := range []time.Duration{time.Microsecond, time.Millisecond, 5 * time.Millisecond, time.Second} {
+		m.OpStarted(context.Background(), sqltracing.OpSQLConnExec)
+		m.OpFinished(context.Background(), &sqltracing.OpObservation{
+			Op:        sqltracing.OpSQLConnExec,
+			QueryName: "q",
+			Duration:  d,
+			Outcome:   sqltracing.OutcomeSuccess,
+		})
+	}
+
+	snapshot := m.Snapshot()
+
+	assert.Equal(t, []time.Duration{time.Millisecond, 10 * time.Millisecond}, snapshot.Buckets)
+	assert.Equal(t, int64(0), snapshot.InFlight[sqltracing.OpSQLConnExec])
+
+	require.Len(t, snapshot.Series, 1)
+	s := snapshot.Series[0]
+
+	assert.Equal(t, Labels{Op: sqltracing.OpSQLConnExec, Outcome: sqltracing.OutcomeSuccess}, s.Labels)
+	assert.Equal(t, uint64(4), s.Count)
+	assert.Equal(t, time.Second+6*time.Millisecond+time.Microsecond, s.Sum)
+	assert.Equal(t, []uint64{2, 3}, s.BucketCounts)
+}
+
+func TestQueryNameLabel(t *testing.T) {
+	m := New(WithQueryNameLabel())
+
+	for _, name := range []string{"b", "a", "b"} {
+		m.OpStarted(context.Background(), sqltracing.OpSQLConnQuery)
+		m.OpFinished(context.Background(), &sqltracing.OpObservation{
+			Op:         sqltracing.OpSQLConnQuery,
+			QueryName:  name,
+			Outcome:    sqltracing.OutcomeError,
+			ErrorClass: sqltracing.ErrorClassOther,
+		})
+	}
+
+	snapshot := m.Snapshot()
+
+	require.Len(t, snapshot.Series, 2)
+	assert.Equal(t, "a", snapshot.Series[0].QueryName)
+	assert.Equal(t, uint64(1), snapshot.Series[0].Count)
+	assert.Equal(t, "b", snapshot.Series[1].QueryName)
+	assert.Equal(t, uint64(2), snapshot.Series[1].Count)
+
+	m.Reset()
+	assert.Empty(t, m.Snapshot().Series)
+}
+
+func TestFingerprintLabel(t *testing.T) {
+	m := New(WithFingerprintLabel())
+
+	for _, fp := range []string{"02", "01", "02"} {
+		m.OpStarted(context.Background(), sqltracing.OpSQLConnQuery)
+		m.OpFinished(context.Background(), &sqltracing.OpObservation{
+			Op:          sqltracing.OpSQLConnQuery,
+			QueryName:   "q",
+			Fingerprint: fp,
+			Outcome:     sqltracing.OutcomeSuccess,
+		})
+	}
+
+	snapshot := m.Snapshot()
+
+	require.Len(t, snapshot.Series, 2)
+	assert.Equal(t, Labels{Op: sqltracing.OpSQLConnQuery, Outcome: sqltracing.OutcomeSuccess, Fingerprint: "01"}, snapshot.Series[0].Labels)
+	assert.Equal(t, uint64(1), snapshot.Series[0].Count)
+	assert.Equal(t, "02", snapshot.Series[1].Fingerprint)
+	assert.Equal(t, uint64(2), snapshot.Series[1].Count)
+}
@@ -0,0 +1,368 @@
+// Package prometheus provides an http.Handler that exposes the metrics of an
+// inprocess.Metrics recorder in the Prometheus text exposition format,
+// without depending on the Prometheus client library.
+//
+// If the scraper accepts the OpenMetrics format, the metrics are rendered as
+// OpenMetrics, including exemplars when they are recorded.
+package prometheus
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/simplesurance/sqltracing"
+	"github.com/simplesurance/sqltracing/metrics/inprocess"
+)
+
+// DefaultNamespace is the default prefix of the metric names.
+const DefaultNamespace = "sqltracing"
+
+// Defines the content types of the supported exposition formats.
+const (
+	ContentTypeText        = "text/plain; version=0.0.4; charset=utf-8"
+	ContentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+type handler struct {
+	metrics   *inprocess.Metrics
+	namespace string
+}
+
+// Opt is a type for options that can be passed to NewHandler.
+type Opt func(*handler)
+
+// WithNamespace is an option for NewHandler() to set the prefix of the
+// metric names.
+func WithNamespace(namespace string) Opt {
+	return func(h *handler) {
+		h.namespace = namespace
+	}
+}
+
+// NewHandler returns an http.Handler that renders the metrics of m.
+// The following metrics are exposed, prefixed with the namespace:
+//   - operation_duration_seconds: histogram of the operation latency,
+//   - operation_errors_total: counter of failed operations,
+//   - operations_in_flight: gauge of currently running operations,
+//   - pool_*: connection pool statistics, if they are reported by a
+//     sqltracing.PoolStatsCollector.
+func NewHandler(m *inprocess.Metrics, opts ...Opt) http.Handler {
+	h := handler{
+		metrics:   m,
+		namespace: DefaultNamespace,
+	}
+
+	for _, opt := range opts {
+		opt(&h)
+	}
+
+	return &h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	openMetrics := acceptsOpenMetrics(r.Header.Get("Accept"))
+
+	var buf bytes.Buffer
+	h.render(&buf, h.metrics.Snapshot(), openMetrics)
+
+	if openMetrics {
+		w.Header().Set("Content-Type", ContentTypeOpenMetrics)
+	} else {
+		w.Header().Set("Content-Type", ContentTypeText)
+	}
+
+	_, _ = buf.WriteTo(w)
+}
+
+func acceptsOpenMetrics(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if mediaType == "application/openmetrics-text" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *handler) render(w io.Writer, snapshot *inprocess.Snapshot, openMetrics bool) {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	h.renderDurations(bw, snapshot, openMetrics)
+	h.renderErrors(bw, snapshot, openMetrics)
+	h.renderInFlight(bw, snapshot)
+	h.renderPools(bw, snapshot, openMetrics)
+
+	if openMetrics {
+		bw.WriteString("# EOF\n")
+	}
+}
+
+func (h *handler) renderDurations(w *bufio.Writer, snapshot *inprocess.Snapshot, openMetrics bool) {
+	name := h.namespace + "_operation_duration_seconds"
+
+	writeHeader(w, name, "histogram", "Duration of database operations.")
+
+	for _, s := range snapshot.Series {
+		labels := seriesLabels(s)
+
+		for i, bound := range snapshot.Buckets {
+			writeSample(w, name+"_bucket", append(labels, label{"le", formatSeconds(bound)}), formatCount(s.BucketCounts[i]))
+			if openMetrics {
+				writeExemplar(w, s.Exemplars[i])
+			}
+			w.WriteByte('\n')
+		}
+
+		writeSample(w, name+"_bucket", append(labels, label{"le", "+Inf"}), formatCount(s.Count))
+		if openMetrics {
+			writeExemplar(w, s.Exemplars[len(snapshot.Buckets)])
+		}
+		w.WriteByte('\n')
+
+		writeSample(w, name+"_sum", labels, formatFloat(s.Sum.Seconds()))
+		w.WriteByte('\n')
+		writeSample(w, name+"_count", labels, formatCount(s.Count))
+		w.WriteByte('\n')
+	}
+}
+
+func (h *handler) renderErrors(w *bufio.Writer, snapshot *inprocess.Snapshot, openMetrics bool) {
+	type errKey struct {
+		op         sqltracing.SQLOp
+		errorClass string
+	}
+
+	name := h.namespace + "_operation_errors"
+	if openMetrics {
+		writeHeader(w, name, "counter", "Number of failed database operations.")
+	} else {
+		writeHeader(w, name+"_total", "counter", "Number of failed database operations.")
+	}
+
+	counts := map[errKey]uint64{}
+	var keys []errKey
+
+	for _, s := range snapshot.Series {
+		if s.Outcome != sqltracing.OutcomeError {
+			continue
+		}
+
+		key := errKey{op: s.Op, errorClass: s.ErrorClass}
+		if _, exist := counts[key]; !exist {
+			keys = append(keys, key)
+		}
+
+		counts[key] += s.Count
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].op != keys[j].op {
+			return keys[i].op < keys[j].op
+		}
+
+		return keys[i].errorClass < keys[j].errorClass
+	})
+
+	for _, key := range keys {
+		writeSample(w, name+"_total", []label{
+			{"op", key.op.String()},
+			{"error_class", key.errorClass},
+		}, formatCount(counts[key]))
+		w.WriteByte('\n')
+	}
+}
+
+func (h *handler) renderInFlight(w *bufio.Writer, snapshot *inprocess.Snapshot) {
+	name := h.namespace + "_operations_in_flight"
+
+	writeHeader(w, name, "gauge", "Number of currently running database operations.")
+
+	ops := make([]sqltracing.SQLOp, 0, len(snapshot.InFlight))
+	for op := range snapshot.InFlight {
+		ops = append(ops, op)
+	}
+
+	sort.Slice(ops, func(i, j int) bool { return ops[i] < ops[j] })
+
+	for _, op := range ops {
+		writeSample(w, name, []label{{"op", op.String()}}, strconv.FormatInt(snapshot.InFlight[op], 10))
+		w.WriteByte('\n')
+	}
+}
+
+func (h *handler) renderPools(w *bufio.Writer, snapshot *inprocess.Snapshot, openMetrics bool) {
+	if len(snapshot.Pools) == 0 {
+		return
+	}
+
+	gauges := []struct {
+		name  string
+		help  string
+		value func(*sqltracing.PoolStats) string
+	}{
+		{"pool_max_open_connections", "Maximum number of open connections.", func(s *sqltracing.PoolStats) string {
+			return strconv.Itoa(s.MaxOpenConnections)
+		}},
+		{"pool_open_connections", "Number of open connections.", func(s *sqltracing.PoolStats) string {
+			return strconv.Itoa(s.OpenConnections)
+		}},
+		{"pool_in_use_connections", "Number of connections that are in use.", func(s *sqltracing.PoolStats) string {
+			return strconv.Itoa(s.InUse)
+		}},
+		{"pool_idle_connections", "Number of idle connections.", func(s *sqltracing.PoolStats) string {
+			return strconv.Itoa(s.Idle)
+		}},
+	}
+
+	for _, g := range gauges {
+		name := h.namespace + "_" + g.name
+		writeHeader(w, name, "gauge", g.help)
+
+		for _, stats := range snapshot.Pools {
+			writeSample(w, name, []label{{"pool", stats.Name}}, g.value(stats))
+			w.WriteByte('\n')
+		}
+	}
+
+	counters := []struct {
+		name  string
+		help  string
+		value func(*sqltracing.PoolStats) []string
+	}{
+		{"pool_wait", "Number of connections that were waited for.", func(s *sqltracing.PoolStats) []string {
+			return []string{strconv.FormatInt(s.WaitCount, 10)}
+		}},
+		{"pool_wait_duration_seconds", "Time spent waiting for connections.", func(s *sqltracing.PoolStats) []string {
+			return []string{formatFloat(s.WaitDuration.Seconds())}
+		}},
+		{"pool_closed_connections", "Number of closed connections by reason.", func(s *sqltracing.PoolStats) []string {
+			return []string{
+				strconv.FormatInt(s.MaxIdleClosed, 10),
+				strconv.FormatInt(s.MaxIdleTimeClosed, 10),
+				strconv.FormatInt(s.MaxLifetimeClosed, 10),
+			}
+		}},
+	}
+
+	closeReasons := []string{"max_idle", "max_idle_time", "max_lifetime"}
+
+	for _, c := range counters {
+		name := h.namespace + "_" + c.name
+		if openMetrics {
+			writeHeader(w, name, "counter", c.help)
+		} else {
+			writeHeader(w, name+"_total", "counter", c.help)
+		}
+
+		for _, stats := range snapshot.Pools {
+			values := c.value(stats)
+			for i, v := range values {
+				labels := []label{{"pool", stats.Name}}
+				if len(values) > 1 {
+					labels = append(labels, label{"reason", closeReasons[i]})
+				}
+
+				writeSample(w, name+"_total", labels, v)
+				w.WriteByte('\n')
+			}
+		}
+	}
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+func seriesLabels(s *inprocess.SeriesSnapshot) []label {
+	labels := make([]label, 0, 6)
+	labels = append(labels,
+		label{"op", s.Op.String()},
+		label{"outcome", s.Outcome},
+		label{"error_class", s.ErrorClass},
+	)
+
+	if s.QueryName != "" {
+		labels = append(labels, label{"query_name", s.QueryName})
+	}
+
+	if s.Fingerprint != "" {
+		labels = append(labels, label{"query_fingerprint", s.Fingerprint})
+	}
+
+	return labels
+}
+
+func writeHeader(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+// writeSample writes a sample without the terminating newline.
+func writeSample(w *bufio.Writer, name string, labels []label, value string) {
+	w.WriteString(name)
+	writeLabels(w, labels)
+	w.WriteByte(' ')
+	w.WriteString(value)
+}
+
+func writeLabels(w *bufio.Writer, labels []label) {
+	if len(labels) == 0 {
+		return
+	}
+
+	w.WriteByte('{')
+
+	for i, l := range labels {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+
+		w.WriteString(l.name)
+		w.WriteString(`="`)
+		w.WriteString(escapeLabelValue(l.value))
+		w.WriteByte('"')
+	}
+
+	w.WriteByte('}')
+}
+
+func writeExemplar(w *bufio.Writer, e *inprocess.Exemplar) {
+	if e == nil {
+		return
+	}
+
+	w.WriteString(" # ")
+	writeLabels(w, []label{{"trace_id", e.TraceID}})
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(e.Value.Seconds()))
+	w.WriteByte(' ')
+	w.WriteString(strconv.FormatFloat(float64(e.Timestamp.UnixNano())/1e9, 'f', 3, 64))
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+func formatSeconds(d time.Duration) string {
+	return formatFloat(d.Seconds())
+}
+
+func formatCount(v uint64) string {
+	return strconv.FormatUint(v, 10)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
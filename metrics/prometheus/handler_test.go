@@ -0,0 +1,125 @@
+package prometheus
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simplesurance/sqltracing"
+	"github.com/simplesurance/sqltracing/metrics/inprocess"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type traceIDCtxKey struct{}
+
+func newTestMetrics() *inprocess.Metrics {
+	m := inprocess.New(
+		inprocess.WithBuckets(time.Millisecond, time.Second),
+		inprocess.WithExemplars(func(ctx context.Context) string {
+			id, _ := ctx.Value(traceIDCtxKey{}).(string)
+			return id
+		}),
+	)
+
+	ctx := context.WithValue(context.Background(), traceIDCtxKey{}, "abc")
+
+	m.OpStarted(ctx, sqltracing.OpSQLConnQuery)
+	m.OpFinished(ctx, &sqltracing.OpObservation{
+		Op:       sqltracing.OpSQLConnQuery,
+		Duration: 500 * time.Millisecond,
+		Outcome:  sqltracing.OutcomeSuccess,
+	})
+
+	m.OpStarted(ctx, sqltracing.OpSQLConnExec)
+	m.OpFinished(context.Background(), &sqltracing.OpObservation{
+		Op:         sqltracing.OpSQLConnExec,
+		Duration:   2 * time.Second,
+		Outcome:    sqltracing.OutcomeError,
+		ErrorClass: sqltracing.ErrorClassTimeout,
+	})
+
+	m.OpStarted(ctx, sqltracing.OpSQLConnExec)
+
+	stats := sqltracing.PoolStats{Name: "main"}
+	stats.OpenConnections = 3
+	stats.InUse = 2
+	stats.WaitDuration = 1500 * time.Millisecond
+	stats.MaxLifetimeClosed = 4
+	m.PoolStats(&stats)
+
+	return m
+}
+
+func scrape(t *testing.T, h http.Handler, accept string) (string, string) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+
+	return rec.Header().Get("Content-Type"), string(body)
+}
+
+func TestTextFormat(t *testing.T) {
+	contentType, body := scrape(t, NewHandler(newTestMetrics()), "")
+
+	assert.Equal(t, ContentTypeText, contentType)
+
+	for _, line := range []string{
+		"# TYPE sqltracing_operation_duration_seconds histogram",
+		`sqltracing_operation_duration_seconds_bucket{op="sql-conn-query",outcome="success",error_class="",le="0.001"} 0`,
+		`sqltracing_operation_duration_seconds_bucket{op="sql-conn-query",outcome="success",error_class="",le="1"} 1`,
+		`sqltracing_operation_duration_seconds_bucket{op="sql-conn-query",outcome="success",error_class="",le="+Inf"} 1`,
+		`sqltracing_operation_duration_seconds_sum{op="sql-conn-query",outcome="success",error_class=""} 0.5`,
+		`sqltracing_operation_duration_seconds_count{op="sql-conn-exec",outcome="error",error_class="timeout"} 1`,
+		"# TYPE sqltracing_operation_errors_total counter",
+		`sqltracing_operation_errors_total{op="sql-conn-exec",error_class="timeout"} 1`,
+		"# TYPE sqltracing_operations_in_flight gauge",
+		`sqltracing_operations_in_flight{op="sql-conn-exec"} 1`,
+		`sqltracing_operations_in_flight{op="sql-conn-query"} 0`,
+		`sqltracing_pool_open_connections{pool="main"} 3`,
+		`sqltracing_pool_in_use_connections{pool="main"} 2`,
+		"# TYPE sqltracing_pool_wait_duration_seconds_total counter",
+		`sqltracing_pool_wait_duration_seconds_total{pool="main"} 1.5`,
+		`sqltracing_pool_closed_connections_total{pool="main",reason="max_lifetime"} 4`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+
+	assert.NotContains(t, body, "trace_id")
+	assert.NotContains(t, body, "# EOF")
+}
+
+func TestOpenMetricsFormat(t *testing.T) {
+	contentType, body := scrape(
+		t,
+		NewHandler(newTestMetrics(), WithNamespace("db")),
+		"application/openmetrics-text;version=1.0.0,text/plain;q=0.5",
+	)
+
+	assert.Equal(t, ContentTypeOpenMetrics, contentType)
+
+	assert.Contains(t, body, `db_operation_duration_seconds_bucket{op="sql-conn-query",outcome="success",error_class="",le="1"} 1 # {trace_id="abc"} 0.5 `)
+	assert.Contains(t, body, `db_operation_duration_seconds_bucket{op="sql-conn-exec",outcome="error",error_class="timeout",le="+Inf"} 1`+"\n")
+	assert.Contains(t, body, "# TYPE db_operation_errors counter\n")
+	assert.Contains(t, body, `db_operation_errors_total{op="sql-conn-exec",error_class="timeout"} 1`+"\n")
+	assert.True(t, strings.HasSuffix(body, "# EOF\n"))
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	assert.Equal(t, `a\"b\\c\nd`, escapeLabelValue("a\"b\\c\nd"))
+}
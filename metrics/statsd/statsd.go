@@ -0,0 +1,360 @@
+// Package statsd provides a Metrics implementation that is compatible with
+// the sqltracing.Metrics interface and sends the metrics via UDP to a StatsD
+// or DogStatsD agent.
+//
+// Multiple metrics are packed into one datagram. They are buffered and sent
+// when the buffer is full, periodically and when the Sink is closed.
+package statsd
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/simplesurance/sqltracing"
+)
+
+// Defines the defaults of the Sink options.
+const (
+	DefaultPrefix        = "sqltracing."
+	DefaultFlushInterval = time.Second
+	// DefaultMaxPacketSize is the maximum size of a datagram that fits
+	// into an ethernet frame.
+	DefaultMaxPacketSize = 1432
+)
+
+// ErrMetricTooLarge is passed to the error handler when a metric is
+// dropped because it exceeds the maximum packet size.
+var ErrMetricTooLarge = errors.New("statsd: metric exceeds the maximum packet size")
+
+// Sink sends metrics for database operations to a StatsD agent.
+// For every finished operation a timer with the duration, a counter and, if
+// the operation failed, an error counter are sent.
+type Sink struct {
+	conn          net.Conn
+	prefix        string
+	tags          []string
+	dogStatsD     bool
+	sampleRate    float64
+	flushInterval time.Duration
+	maxPacketSize int
+	errorHandler  func(error)
+
+	mu      sync.Mutex
+	buf     []byte
+	dropped uint64
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Opt is a type for options that can be passed to New.
+type Opt func(*Sink)
+
+// WithPrefix is an option for New() to set the prefix of the metric names.
+func WithPrefix(prefix string) Opt {
+	return func(s *Sink) {
+		s.prefix = prefix
+	}
+}
+
+// WithDBInstance is an option for New() to add a "db.instance" tag with the
+// passed name to all metrics.
+func WithDBInstance(name string) Opt {
+	return func(s *Sink) {
+		s.tags = append(s.tags, "db.instance:"+name)
+	}
+}
+
+// WithTags is an option for New() to add tags to all metrics.
+// The tags must be in the DogStatsD "key:value" format.
+func WithTags(tags ...string) Opt {
+	return func(s *Sink) {
+		s.tags = append(s.tags, tags...)
+	}
+}
+
+// WithoutTags is an option for New() to not send any tags, for agents that do
+// not support the DogStatsD tag extension.
+func WithoutTags() Opt {
+	return func(s *Sink) {
+		s.dogStatsD = false
+	}
+}
+
+// WithSampleRate is an option for New() to only send the metrics of a
+// fraction of operations. rate must be in the range (0, 1], otherwise all
+// operations are sent.
+// The rate is sent with the metrics, to allow the agent to scale the values.
+func WithSampleRate(rate float64) Opt {
+	return func(s *Sink) {
+		s.sampleRate = rate
+	}
+}
+
+// WithFlushInterval is an option for New() to set the interval in which
+// buffered metrics are sent.
+// If d is not positive, DefaultFlushInterval is used.
+func WithFlushInterval(d time.Duration) Opt {
+	return func(s *Sink) {
+		s.flushInterval = d
+	}
+}
+
+// WithMaxPacketSize is an option for New() to set the maximum size of a
+// datagram. Metrics that exceed the size on their own are dropped.
+// If size is not positive, DefaultMaxPacketSize is used.
+func WithMaxPacketSize(size int) Opt {
+	return func(s *Sink) {
+		s.maxPacketSize = size
+	}
+}
+
+// WithErrorHandler is an option for New() to set a function that is called
+// when sending metrics fails. By default errors are ignored.
+func WithErrorHandler(fn func(error)) Opt {
+	return func(s *Sink) {
+		s.errorHandler = fn
+	}
+}
+
+// New returns a Sink that sends metrics to the StatsD agent listening on the
+// UDP address addr.
+// The Sink must be closed to stop the periodic flushing.
+func New(addr string, opts ...Opt) (*Sink, error) {
+	s := Sink{
+		prefix:        DefaultPrefix,
+		dogStatsD:     true,
+		sampleRate:    1,
+		flushInterval: DefaultFlushInterval,
+		maxPacketSize: DefaultMaxPacketSize,
+		errorHandler:  func(error) {},
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	if s.flushInterval <= 0 {
+		s.flushInterval = DefaultFlushInterval
+	}
+
+	if s.sampleRate <= 0 || s.sampleRate > 1 {
+		s.sampleRate = 1
+	}
+
+	if s.maxPacketSize <= 0 {
+		s.maxPacketSize = DefaultMaxPacketSize
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.conn = conn
+	s.buf = make([]byte, 0, s.maxPacketSize)
+
+	go s.flushPeriodically()
+
+	return &s, nil
+}
+
+// OpStarted implements sqltracing.Metrics.
+func (s *Sink) OpStarted(_ context.Context, _ sqltracing.SQLOp) {}
+
+// OpFinished implements sqltracing.Metrics.
+func (s *Sink) OpFinished(_ context.Context, obs *sqltracing.OpObservation) {
+	if s.sampleRate < 1 && rand.Float64() >= s.sampleRate {
+		return
+	}
+
+	tags := []string{"op:" + obs.Op.String(), "outcome:" + obs.Outcome}
+	if obs.QueryName != "" {
+		tags = append(tags, "query_name:"+obs.QueryName)
+	}
+
+	if obs.Fingerprint != "" {
+		tags = append(tags, "query_fingerprint:"+obs.Fingerprint)
+	}
+
+	ms := strconv.FormatFloat(float64(obs.Duration)/float64(time.Millisecond), 'f', -1, 64)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.appendMetric("operation.duration", ms, "ms", s.sampleRate, tags)
+	s.appendMetric("operation.count", "1", "c", s.sampleRate, tags)
+
+	if obs.Outcome == sqltracing.OutcomeError {
+		s.appendMetric(
+			"operation.errors", "1", "c", s.sampleRate,
+			[]string{"op:" + obs.Op.String(), "error_class:" + obs.ErrorClass},
+		)
+	}
+}
+
+// PoolStats implements sqltracing.PoolMetrics.
+// The connection counts are sent as gauges, the deltas as counters.
+// Pool statistics are not sampled.
+func (s *Sink) PoolStats(stats *sqltracing.PoolStats) {
+	var tags []string
+	if stats.Name != "" {
+		tags = []string{"pool:" + stats.Name}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.appendMetric("pool.max_open_connections", strconv.Itoa(stats.MaxOpenConnections), "g", 1, tags)
+	s.appendMetric("pool.open_connections", strconv.Itoa(stats.OpenConnections), "g", 1, tags)
+	s.appendMetric("pool.in_use_connections", strconv.Itoa(stats.InUse), "g", 1, tags)
+	s.appendMetric("pool.idle_connections", strconv.Itoa(stats.Idle), "g", 1, tags)
+	s.appendMetric("pool.wait", strconv.FormatInt(stats.WaitCountDelta, 10), "c", 1, tags)
+	s.appendMetric("pool.wait_duration", strconv.FormatInt(stats.WaitDurationDelta.Milliseconds(), 10), "c", 1, tags)
+	s.appendMetric("pool.closed.max_idle", strconv.FormatInt(stats.MaxIdleClosedDelta, 10), "c", 1, tags)
+	s.appendMetric("pool.closed.max_idle_time", strconv.FormatInt(stats.MaxIdleTimeClosedDelta, 10), "c", 1, tags)
+	s.appendMetric("pool.closed.max_lifetime", strconv.FormatInt(stats.MaxLifetimeClosedDelta, 10), "c", 1, tags)
+}
+
+// appendMetric adds a metric to the buffer. If the buffer would exceed the
+// maximum packet size, it is flushed first. Metrics that exceed the maximum
+// packet size on their own are dropped and reported to the error handler.
+// s.mu must be held when calling the method.
+func (s *Sink) appendMetric(name, value, typ string, rate float64, tags []string) {
+	line := s.formatMetric(name, value, typ, rate, tags)
+
+	if len(line) > s.maxPacketSize {
+		s.dropped++
+		s.errorHandler(ErrMetricTooLarge)
+
+		return
+	}
+
+	if len(s.buf) > 0 && len(s.buf)+1+len(line) > s.maxPacketSize {
+		_ = s.flushLocked()
+	}
+
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+
+	s.buf = append(s.buf, line...)
+}
+
+func (s *Sink) formatMetric(name, value, typ string, rate float64, tags []string) string {
+	var sb strings.Builder
+
+	sb.WriteString(s.prefix)
+	sb.WriteString(name)
+	sb.WriteByte(':')
+	sb.WriteString(value)
+	sb.WriteByte('|')
+	sb.WriteString(typ)
+
+	if rate < 1 {
+		sb.WriteString("|@")
+		sb.WriteString(strconv.FormatFloat(rate, 'f', -1, 64))
+	}
+
+	if s.dogStatsD && len(tags)+len(s.tags) > 0 {
+		sb.WriteString("|#")
+		sb.WriteString(strings.Join(tags, ","))
+
+		if len(tags) > 0 && len(s.tags) > 0 {
+			sb.WriteByte(',')
+		}
+
+		sb.WriteString(strings.Join(s.tags, ","))
+	}
+
+	return sb.String()
+}
+
+// Dropped returns the number of metrics that were dropped because they
+// exceeded the maximum packet size.
+func (s *Sink) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.dropped
+}
+
+// Flush sends all buffered metrics.
+func (s *Sink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.flushLocked()
+}
+
+func (s *Sink) flushLocked() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+
+	_, err := s.conn.Write(s.buf)
+	s.buf = s.buf[:0]
+
+	if err != nil {
+		s.errorHandler(err)
+	}
+
+	return err
+}
+
+func (s *Sink) flushPeriodically() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_ = s.Flush()
+
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops the periodic flushing, sends all buffered metrics and closes
+// the connection.
+// Calling Close multiple times is safe, further calls return the result of
+// the first one.
+func (s *Sink) Close() error {
+	s.closeOnce.Do(func() {
+		s.closeErr = s.close()
+	})
+
+	return s.closeErr
+}
+
+func (s *Sink) close() error {
+	close(s.stop)
+	<-s.done
+
+	flushErr := s.Flush()
+
+	if err := s.conn.Close(); err != nil {
+		return err
+	}
+
+	return flushErr
+}
+
+var (
+	_ sqltracing.Metrics     = &Sink{}
+	_ sqltracing.PoolMetrics = &Sink{}
+)
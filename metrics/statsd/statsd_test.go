@@ -0,0 +1,239 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simplesurance/sqltracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listen(t *testing.T) net.PacketConn {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { pc.Close() })
+
+	return pc
+}
+
+func readDatagram(t *testing.T, pc net.PacketConn) string {
+	t.Helper()
+
+	buf := make([]byte, 65536)
+
+	require.NoError(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	n, _, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+
+	return string(buf[:n])
+}
+
+func TestSink(t *testing.T) {
+	pc := listen(t)
+
+	sink, err := New(
+		pc.LocalAddr().String(),
+		WithDBInstance("orders"),
+		WithFlushInterval(time.Hour),
+	)
+	require.NoError(t, err)
+
+	sink.OpFinished(context.Background(), &sqltracing.OpObservation{
+		Op:       sqltracing.OpSQLConnQuery,
+		Duration: 1500 * time.Microsecond,
+		Outcome:  sqltracing.OutcomeSuccess,
+	})
+
+	sink.OpFinished(context.Background(), &sqltracing.OpObservation{
+		Op:         sqltracing.OpSQLConnExec,
+		Duration:   time.Second,
+		Outcome:    sqltracing.OutcomeError,
+		ErrorClass: sqltracing.ErrorClassTimeout,
+	})
+
+	require.NoError(t, sink.Close())
+
+	assert.Equal(t, strings.Join([]string{
+		"sqltracing.operation.duration:1.5|ms|#op:sql-conn-query,outcome:success,db.instance:orders",
+		"sqltracing.operation.count:1|c|#op:sql-conn-query,outcome:success,db.instance:orders",
+		"sqltracing.operation.duration:1000|ms|#op:sql-conn-exec,outcome:error,db.instance:orders",
+		"sqltracing.operation.count:1|c|#op:sql-conn-exec,outcome:error,db.instance:orders",
+		"sqltracing.operation.errors:1|c|#op:sql-conn-exec,error_class:timeout,db.instance:orders",
+	}, "\n"), readDatagram(t, pc))
+}
+
+func TestSinkMaxPacketSize(t *testing.T) {
+	pc := listen(t)
+
+	sink, err := New(
+		pc.LocalAddr().String(),
+		WithoutTags(),
+		WithPrefix(""),
+		WithMaxPacketSize(64),
+		WithFlushInterval(time.Hour),
+	)
+	require.NoError(t, err)
+
+	sink.OpFinished(context.Background(), &sqltracing.OpObservation{
+		Op:       sqltracing.OpSQLPing,
+		Duration: 2 * time.Millisecond,
+		Outcome:  sqltracing.OutcomeSuccess,
+	})
+
+	// the first datagram is sent when the buffer is full
+	sink.OpFinished(context.Background(), &sqltracing.OpObservation{
+		Op:       sqltracing.OpSQLPing,
+		Duration: 3 * time.Millisecond,
+		Outcome:  sqltracing.OutcomeSuccess,
+	})
+
+	assert.Equal(t, "operation.duration:2|ms\noperation.count:1|c", readDatagram(t, pc))
+
+	require.NoError(t, sink.Close())
+
+	assert.Equal(t, "operation.duration:3|ms\noperation.count:1|c", readDatagram(t, pc))
+}
+
+func TestSinkPeriodicFlush(t *testing.T) {
+	pc := listen(t)
+
+	sink, err := New(
+		pc.LocalAddr().String(),
+		WithSampleRate(0.9999999),
+		WithFlushInterval(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	defer sink.Close()
+
+	sink.OpFinished(context.Background(), &sqltracing.OpObservation{
+		Op:      sqltracing.OpSQLPing,
+		Outcome: sqltracing.OutcomeSuccess,
+	})
+
+	datagram := readDatagram(t, pc)
+	assert.Contains(t, datagram, "sqltracing.operation.count:1|c|@0.9999999|#op:sql-ping,outcome:success")
+}
+
+func TestSinkSampling(t *testing.T) {
+	pc := listen(t)
+
+	sink, err := New(pc.LocalAddr().String(), WithSampleRate(0.0000001))
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		sink.OpFinished(context.Background(), &sqltracing.OpObservation{
+			Op:      sqltracing.OpSQLPing,
+			Outcome: sqltracing.OutcomeSuccess,
+		})
+	}
+
+	sink.mu.Lock()
+	assert.Empty(t, sink.buf)
+	sink.mu.Unlock()
+
+	require.NoError(t, sink.Close())
+}
+
+func TestSinkPoolStats(t *testing.T) {
+	pc := listen(t)
+
+	sink, err := New(pc.LocalAddr().String(), WithSampleRate(0.5), WithFlushInterval(time.Hour))
+	require.NoError(t, err)
+
+	stats := sqltracing.PoolStats{Name: "main", WaitDurationDelta: 2 * time.Second}
+	stats.InUse = 2
+	sink.PoolStats(&stats)
+
+	require.NoError(t, sink.Close())
+
+	datagram := readDatagram(t, pc)
+	assert.Contains(t, datagram, "sqltracing.pool.in_use_connections:2|g|#pool:main\n")
+	assert.Contains(t, datagram, "sqltracing.pool.wait_duration:2000|c|#pool:main\n")
+}
+
+func TestSinkInvalidFlushIntervalAndCloseTwice(t *testing.T) {
+	pc := listen(t)
+
+	for _, d := range []time.Duration{0, -time.Second} {
+		sink, err := New(pc.LocalAddr().String(), WithFlushInterval(d))
+		require.NoError(t, err)
+
+		assert.Equal(t, DefaultFlushInterval, sink.flushInterval)
+
+		require.NoError(t, sink.Close())
+		require.NoError(t, sink.Close())
+	}
+}
+
+func TestSinkInvalidSampleRate(t *testing.T) {
+	pc := listen(t)
+
+	for _, rate := range []float64{0, -1, 1.5} {
+		sink, err := New(pc.LocalAddr().String(), WithSampleRate(rate), WithFlushInterval(time.Hour))
+		require.NoError(t, err)
+
+		sink.OpFinished(context.Background(), &sqltracing.OpObservation{
+			Op:      sqltracing.OpSQLPing,
+			Outcome: sqltracing.OutcomeSuccess,
+		})
+
+		require.NoError(t, sink.Close())
+
+		datagram := readDatagram(t, pc)
+		assert.Contains(t, datagram, "sqltracing.operation.count:1|c|#op:sql-ping,outcome:success")
+		assert.NotContains(t, datagram, "|@")
+	}
+}
+
+func TestSinkDoesNotModifyTags(t *testing.T) {
+	pc := listen(t)
+
+	sink, err := New(pc.LocalAddr().String(), WithTags("env:test"), WithFlushInterval(time.Hour))
+	require.NoError(t, err)
+
+	defer sink.Close()
+
+	tags := make([]string, 1, 2)
+	tags[0] = "op:a"
+
+	assert.Equal(t, "sqltracing.m:1|c|#op:a,env:test", sink.formatMetric("m", "1", "c", 1, tags))
+	assert.Equal(t, "", tags[:2][1])
+}
+
+func TestSinkDropsOversizedMetrics(t *testing.T) {
+	pc := listen(t)
+
+	var errs []error
+
+	sink, err := New(
+		pc.LocalAddr().String(),
+		WithoutTags(),
+		WithPrefix(""),
+		WithMaxPacketSize(20),
+		WithFlushInterval(time.Hour),
+		WithErrorHandler(func(err error) { errs = append(errs, err) }),
+	)
+	require.NoError(t, err)
+
+	sink.OpFinished(context.Background(), &sqltracing.OpObservation{
+		Op:       sqltracing.OpSQLPing,
+		Duration: 2 * time.Millisecond,
+		Outcome:  sqltracing.OutcomeSuccess,
+	})
+
+	require.NoError(t, sink.Close())
+
+	// operation.duration:2|ms exceeds the packet size
+	assert.Equal(t, "operation.count:1|c", readDatagram(t, pc))
+	assert.Equal(t, uint64(1), sink.Dropped())
+	assert.Equal(t, []error{ErrMetricTooLarge}, errs)
+}
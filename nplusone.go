@@ -0,0 +1,206 @@
+package sqltracing
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defines the names of the tracing tags that are set on spans of queries
+// that are part of a N+1 query pattern.
+const (
+	DBNPlusOneTagKey      = "db.n_plus_one"
+	DBNPlusOneCountTagKey = "db.n_plus_one.count"
+)
+
+// DefaultNPlusOneThreshold is the default number of executions of the same
+// query under the same parent span at which it is reported as N+1 query.
+const DefaultNPlusOneThreshold = 10
+
+// DefaultNPlusOneIdleTimeout is the default duration after which the
+// counters of a parent span are discarded when no queries were executed
+// under it.
+const DefaultNPlusOneIdleTimeout = time.Minute
+
+// NPlusOneEvent describes a detected N+1 query pattern.
+type NPlusOneEvent struct {
+	// Query is the normalized query.
+	Query       string
+	Fingerprint uint64
+	// Count is the number of executions of the query under the parent
+	// span.
+	Count int
+	// TraceID is the ID of the trace, if the Span implements SpanIDer.
+	TraceID string
+}
+
+// NPlusOneDetector detects queries that are executed repeatedly under the
+// same parent span, which is a typical sign of the N+1 query problem.
+// Queries are identified by their fingerprint.
+//
+// The parent span is retrieved from the context that is passed to the
+// database operations, this requires that the Tracer implements the
+// SpanKeyer interface.
+//
+// When the number of executions of a query reaches the threshold, the
+// callback is invoked. The span of the execution and of all following
+// executions are tagged with DBNPlusOneTagKey and DBNPlusOneCountTagKey.
+type NPlusOneDetector struct {
+	threshold   int
+	idleTimeout time.Duration
+	callback    func(context.Context, *NPlusOneEvent)
+
+	mu        sync.Mutex
+	parents   map[interface{}]*nPlusOneParent
+	lastSweep time.Time
+}
+
+type nPlusOneParent struct {
+	counts   map[uint64]int
+	lastSeen time.Time
+}
+
+// NPlusOneOpt is a type for options for the NPlusOneDetector.
+type NPlusOneOpt func(*NPlusOneDetector)
+
+// WithNPlusOneThreshold sets the number of executions at which a query is
+// reported. The default is DefaultNPlusOneThreshold.
+func WithNPlusOneThreshold(n int) NPlusOneOpt {
+	return func(d *NPlusOneDetector) {
+		d.threshold = n
+	}
+}
+
+// WithNPlusOneIdleTimeout sets the duration after which the counters of a
+// parent span without query executions are discarded.
+// The default is DefaultNPlusOneIdleTimeout, it is also used when timeout is
+// not positive.
+func WithNPlusOneIdleTimeout(timeout time.Duration) NPlusOneOpt {
+	return func(d *NPlusOneDetector) {
+		if timeout <= 0 {
+			timeout = DefaultNPlusOneIdleTimeout
+		}
+
+		d.idleTimeout = timeout
+	}
+}
+
+// WithNPlusOneCallback sets a function that is called when the number of
+// executions of a query under a parent span reaches the threshold.
+// ctx is the context that was passed to the database operation.
+// It is called synchronously and once per query and parent span.
+func WithNPlusOneCallback(fn func(ctx context.Context, ev *NPlusOneEvent)) NPlusOneOpt {
+	return func(d *NPlusOneDetector) {
+		d.callback = fn
+	}
+}
+
+// NewNPlusOneDetector returns a new NPlusOneDetector.
+// It is passed via WithNPlusOneDetector to an Interceptor.
+func NewNPlusOneDetector(opts ...NPlusOneOpt) *NPlusOneDetector {
+	d := NPlusOneDetector{
+		threshold:   DefaultNPlusOneThreshold,
+		idleTimeout: DefaultNPlusOneIdleTimeout,
+		parents:     map[interface{}]*nPlusOneParent{},
+		lastSweep:   time.Now(),
+	}
+
+	for _, opt := range opts {
+		opt(&d)
+	}
+
+	return &d
+}
+
+// observe counts the execution of the query with the fingerprint under the
+// parent span parentKey and returns the number of executions.
+func (d *NPlusOneDetector) observe(parentKey interface{}, fingerprint uint64) int {
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Sub(d.lastSweep) >= d.idleTimeout {
+		d.sweep(now)
+	}
+
+	parent, exist := d.parents[parentKey]
+	if !exist {
+		parent = &nPlusOneParent{counts: map[uint64]int{}}
+		d.parents[parentKey] = parent
+	}
+
+	parent.lastSeen = now
+	parent.counts[fingerprint]++
+
+	return parent.counts[fingerprint]
+}
+
+// sweep removes the counters of parents that exceeded the idle timeout.
+// d.mu must be held when calling the method.
+func (d *NPlusOneDetector) sweep(now time.Time) {
+	for key, parent := range d.parents {
+		if now.Sub(parent.lastSeen) >= d.idleTimeout {
+			delete(d.parents, key)
+		}
+	}
+
+	d.lastSweep = now
+}
+
+type nPlusOneParentCtxKey struct{}
+
+// withNPlusOneParentCtx returns a copy of ctx that contains parentCtx.
+// It is used for operations on prepared statements, their context is
+// derived from the prepare span, which differs per statement. When a
+// driver does not support executing queries without preparing them, each
+// execution has its own statement.
+func withNPlusOneParentCtx(ctx, parentCtx context.Context) context.Context {
+	return context.WithValue(ctx, nPlusOneParentCtxKey{}, parentCtx)
+}
+
+// nPlusOneParentCtx returns the context that was stored via
+// withNPlusOneParentCtx in ctx, if none is stored ctx is returned.
+func nPlusOneParentCtx(ctx context.Context) context.Context {
+	if parentCtx, ok := ctx.Value(nPlusOneParentCtxKey{}).(context.Context); ok {
+		return parentCtx
+	}
+
+	return ctx
+}
+
+// check counts the execution of query under the parent span in parentCtx.
+// When the threshold is reached, span is tagged and the callback is
+// invoked.
+func (d *NPlusOneDetector) check(parentCtx context.Context, tracer Tracer, span Span, query *parsedQuery) {
+	keyer, ok := tracer.(SpanKeyer)
+	if !ok {
+		return
+	}
+
+	parentKey := keyer.SpanKey(parentCtx)
+	if parentKey == nil {
+		return
+	}
+
+	cnt := d.observe(parentKey, query.fingerprint)
+	if cnt < d.threshold {
+		return
+	}
+
+	span.SetTags(map[string]string{
+		DBNPlusOneTagKey:      "true",
+		DBNPlusOneCountTagKey: strconv.Itoa(cnt),
+	})
+
+	if cnt == d.threshold && d.callback != nil {
+		traceID, _ := spanIDs(span)
+		d.callback(parentCtx, &NPlusOneEvent{
+			Query:       query.text,
+			Fingerprint: query.fingerprint,
+			Count:       cnt,
+			TraceID:     traceID,
+		})
+	}
+}
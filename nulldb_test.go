@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql/driver"
 	"io"
+	"reflect"
+	"strconv"
 )
 
 type nullDriver struct {
@@ -29,7 +31,60 @@ func (s *nullStmt) Query(_ []driver.Value) (driver.Rows, error) {
 }
 
 func (s *nullStmt) Exec(_ []driver.Value) (driver.Result, error) {
-	return nil, nil
+	return &nullResult{}, nil
+}
+
+// ctxStmt is a statement that implements the optional context and
+// NamedValueChecker interfaces.
+// lastCtx is the context that was passed to the last ExecContext or
+// QueryContext call. CheckNamedValue converts customArg values to strings.
+// If rows is set, QueryContext returns it.
+type ctxStmt struct {
+	nullStmt
+	lastCtx context.Context
+	rows    driver.Rows
+}
+
+type customArg struct {
+	value string
+}
+
+func (s *ctxStmt) NumInput() int {
+	return -1
+}
+
+func (s *ctxStmt) ExecContext(ctx context.Context, _ []driver.NamedValue) (driver.Result, error) {
+	s.lastCtx = ctx
+	return &nullResult{}, nil
+}
+
+func (s *ctxStmt) QueryContext(ctx context.Context, _ []driver.NamedValue) (driver.Rows, error) {
+	s.lastCtx = ctx
+
+	if s.rows != nil {
+		return s.rows, nil
+	}
+
+	return &nullRows{}, nil
+}
+
+func (s *ctxStmt) CheckNamedValue(v *driver.NamedValue) error {
+	if arg, ok := v.Value.(customArg); ok {
+		v.Value = arg.value
+		return nil
+	}
+
+	return driver.ErrSkip
+}
+
+type nullResult struct{}
+
+func (r *nullResult) LastInsertId() (int64, error) {
+	return 1, nil
+}
+
+func (r *nullResult) RowsAffected() (int64, error) {
+	return 1, nil
 }
 
 type nullRows struct{}
@@ -48,6 +103,79 @@ func (r *nullRows) Next(_ []driver.Value) error {
 	return io.EOF
 }
 
+// valueRows are rows that return values.
+type valueRows struct {
+	values [][]driver.Value
+	pos    int
+}
+
+func (r *valueRows) Close() error {
+	return nil
+}
+
+func (r *valueRows) Columns() []string {
+	cols := make([]string, len(r.values[0]))
+	for i := range cols {
+		cols[i] = strconv.Itoa(i + 1)
+	}
+
+	return cols
+}
+
+func (r *valueRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.values) {
+		return io.EOF
+	}
+
+	copy(dest, r.values[r.pos])
+	r.pos++
+
+	return nil
+}
+
+// resultSetRows are rows with multiple result sets of int64 values.
+// They implement driver.RowsNextResultSet and the
+// driver.RowsColumnTypeScanType, driver.RowsColumnTypeDatabaseTypeName and
+// driver.RowsColumnTypeNullable interfaces.
+type resultSetRows struct {
+	valueRows
+	sets [][][]driver.Value
+}
+
+func newResultSetRows(sets ...[][]driver.Value) *resultSetRows {
+	return &resultSetRows{
+		valueRows: valueRows{values: sets[0]},
+		sets:      sets[1:],
+	}
+}
+
+func (r *resultSetRows) HasNextResultSet() bool {
+	return len(r.sets) > 0
+}
+
+func (r *resultSetRows) NextResultSet() error {
+	if len(r.sets) == 0 {
+		return io.EOF
+	}
+
+	r.valueRows = valueRows{values: r.sets[0]}
+	r.sets = r.sets[1:]
+
+	return nil
+}
+
+func (r *resultSetRows) ColumnTypeScanType(_ int) reflect.Type {
+	return reflect.TypeOf(int64(0))
+}
+
+func (r *resultSetRows) ColumnTypeDatabaseTypeName(_ int) string {
+	return "INT8"
+}
+
+func (r *resultSetRows) ColumnTypeNullable(_ int) (bool, bool) {
+	return true, true
+}
+
 func (t *nullTx) Commit() error {
 	return nil
 }
@@ -56,9 +184,31 @@ func (t *nullTx) Rollback() error {
 	return nil
 }
 
-type nullCon struct{}
+// nullCon is a connection that does nothing.
+// If err is set, it is returned by QueryContext and ExecContext.
+// lastQuery is the query that was passed to the last Prepare, QueryContext
+// or ExecContext call.
+// If block is set, QueryContext and ExecContext block until the context is
+// done and return its error.
+// If rows is set, QueryContext returns rows with the values.
+// If stmt is set, Prepare returns it.
+// If resultSets is set, QueryContext returns resultSetRows with the values.
+type nullCon struct {
+	err        error
+	lastQuery  string
+	block      bool
+	rows       [][]driver.Value
+	resultSets [][][]driver.Value
+	stmt       driver.Stmt
+}
+
+func (c *nullCon) Prepare(query string) (driver.Stmt, error) {
+	c.lastQuery = query
+
+	if c.stmt != nil {
+		return c.stmt, nil
+	}
 
-func (c *nullCon) Prepare(_ string) (driver.Stmt, error) {
 	return &nullStmt{}, nil
 }
 
@@ -68,14 +218,74 @@ func (c *nullCon) Begin() (driver.Tx, error) {
 	return &nullTx{}, nil
 }
 
-func (c *nullCon) QueryContext(_ context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+func (c *nullCon) QueryContext(ctx context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	c.lastQuery = query
+
+	if c.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	if c.rows != nil {
+		return &valueRows{values: c.rows}, nil
+	}
+
+	if c.resultSets != nil {
+		return newResultSetRows(c.resultSets...), nil
+	}
+
 	return &nullRows{}, nil
 }
 
-func (c *nullCon) ExecContext(_ context.Context, _ string, _ []driver.NamedValue) (driver.Result, error) {
-	return nil, nil
+func (c *nullCon) ExecContext(ctx context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.lastQuery = query
+
+	if c.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return &nullResult{}, nil
 }
 
 func (c *nullCon) Ping(_ context.Context) error {
 	return nil
 }
+
+// validatingCon is a nullCon that implements driver.SessionResetter and
+// driver.Validator.
+// IsValid returns false if invalid is set, ResetSession returns resetErr.
+type validatingCon struct {
+	nullCon
+	invalid  bool
+	resetErr error
+}
+
+func (c *validatingCon) ResetSession(_ context.Context) error {
+	return c.resetErr
+}
+
+func (c *validatingCon) IsValid() bool {
+	return !c.invalid
+}
+
+// basicCon is a connection that only implements the methods of driver.Conn.
+type basicCon struct{}
+
+func (c *basicCon) Prepare(_ string) (driver.Stmt, error) {
+	return &nullStmt{}, nil
+}
+
+func (c *basicCon) Close() error { return nil }
+
+func (c *basicCon) Begin() (driver.Tx, error) {
+	return &nullTx{}, nil
+}
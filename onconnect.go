@@ -0,0 +1,64 @@
+package sqltracing
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+// OnConnectFunc is called after a new connection was established, to
+// initialize it, e.g. by setting session parameters.
+// The ExecContext and QueryContext operations on conn are traced as
+// children of the OpSQLConnect span. Rows returned by QueryContext must be
+// closed.
+// The returned tags are set on the spans of all later operations on the
+// connection, e.g. to record the id of the database backend process.
+// If an error is returned, the connection is closed and the error is
+// returned to database/sql.
+type OnConnectFunc func(ctx context.Context, conn driver.Conn) (tags map[string]string, err error)
+
+// runOnConnect runs the OnConnectFunc for conn and stores the returned
+// tags in the connection.
+func (t *Interceptor) runOnConnect(ctx context.Context, conn *tracedConn) error {
+	tags, err := t.onConnect(ctx, &initConn{tracedConn: conn, icp: t})
+	if err != nil {
+		return err
+	}
+
+	conn.tags = tags
+
+	return nil
+}
+
+// initConn is the connection that is passed to the OnConnectFunc.
+// Its operations are run through the Interceptor to trace them.
+type initConn struct {
+	*tracedConn
+	icp *Interceptor
+}
+
+func (c *initConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.icp.ConnExecContext(ctx, c.tracedConn, query, args)
+}
+
+func (c *initConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.icp.ConnQueryContext(ctx, c.tracedConn, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &initRows{Rows: rows, icp: c.icp}, nil
+}
+
+// initRows are the rows returned by initConn.QueryContext.
+type initRows struct {
+	driver.Rows
+	icp *Interceptor
+}
+
+func (r *initRows) Next(dest []driver.Value) error {
+	return r.icp.RowsNext(r.Rows, dest)
+}
+
+func (r *initRows) Close() error {
+	return r.icp.RowsClose(r.Rows)
+}
@@ -20,9 +20,63 @@ const (
 	OpSQLRowsClose  SQLOp = "sql-rows-close"
 	OpSQLPing       SQLOp = "sql-ping"
 	OpSQLConnect    SQLOp = "sql-connect"
+
+	OpSQLRowsNextResultSet SQLOp = "sql-rows-next-result-set"
+
+	OpSQLResetSession SQLOp = "sql-reset-session"
+	OpSQLConnClose    SQLOp = "sql-conn-close"
+	OpSQLConnLifetime SQLOp = "sql-conn-lifetime"
 )
 
 // String returns the string representation of SQLOp.
 func (s SQLOp) String() string {
 	return string(s)
 }
+
+// isStmtOp returns true if the operation runs a prepared statement.
+func (s SQLOp) isStmtOp() bool {
+	return s == OpSQLStmtExec || s == OpSQLStmtQuery
+}
+
+// isQueryExecution returns true if the operation executes a query statement.
+func (s SQLOp) isQueryExecution() bool {
+	return s.hasQuery() && s != OpSQLPrepare
+}
+
+// hasQuery returns true if the operation runs a query statement.
+func (s SQLOp) hasQuery() bool {
+	switch s {
+	case OpSQLPrepare, OpSQLConnExec, OpSQLConnQuery, OpSQLStmtExec, OpSQLStmtQuery:
+		return true
+	default:
+		return false
+	}
+}
+
+// takesConn returns true if database/sql takes a connection from the pool
+// to run the operation.
+func (s SQLOp) takesConn() bool {
+	switch s {
+	case OpSQLConnect, OpSQLTxBegin, OpSQLPrepare, OpSQLConnExec, OpSQLConnQuery, OpSQLStmtExec, OpSQLStmtQuery:
+		return true
+	default:
+		return false
+	}
+}
+
+// isRowsOp returns true if the operation runs on rows.
+func (s SQLOp) isRowsOp() bool {
+	return s == OpSQLRowsNext || s == OpSQLRowsClose || s == OpSQLRowsNextResultSet
+}
+
+// isIssuedByApp returns true if the operation is directly issued by the
+// application. Other operations are run on the rows, statements and
+// transactions that those operations return, or are run by database/sql.
+func (s SQLOp) isIssuedByApp() bool {
+	switch s {
+	case OpSQLConnect, OpSQLPing, OpSQLTxBegin, OpSQLPrepare, OpSQLConnExec, OpSQLConnQuery, OpSQLStmtExec, OpSQLStmtQuery:
+		return true
+	default:
+		return false
+	}
+}
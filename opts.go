@@ -1,10 +1,18 @@
 package sqltracing
 
+import (
+	"regexp"
+	"time"
+
+	"github.com/simplesurance/sqltracing/querynorm"
+)
+
 // Opt is a type for options for the Interceptor.
 type Opt func(*Interceptor)
 
 // WithOpsExcluded can be passed when creating an Interceptor.
-// It excludes recording traces for the passed database operations.
+// It excludes recording traces and metrics for the passed database
+// operations.
 func WithOpsExcluded(ops ...SQLOp) Opt {
 	return func(drv *Interceptor) {
 		for _, op := range ops {
@@ -12,3 +20,310 @@ func WithOpsExcluded(ops ...SQLOp) Opt {
 		}
 	}
 }
+
+// WithRowsAffected can be passed when creating an Interceptor.
+// It calls RowsAffected() on the result of exec operations before their
+// spans are finished and records the value as DBRowsAffectedTagKey tag.
+// It should only be enabled for drivers that return the number of affected
+// rows without an additional roundtrip to the database.
+func WithRowsAffected() Opt {
+	return func(drv *Interceptor) {
+		drv.recordRowsAffected = true
+	}
+}
+
+// WithLastInsertID can be passed when creating an Interceptor.
+// It calls LastInsertId() on the result of exec operations before their
+// spans are finished and records the value as DBLastInsertIDTagKey tag.
+// Like WithRowsAffected, it should only be enabled for drivers that return
+// the id without an additional roundtrip to the database.
+func WithLastInsertID() Opt {
+	return func(drv *Interceptor) {
+		drv.recordLastInsertID = true
+	}
+}
+
+// WithCallSite can be passed when creating an Interceptor.
+// It records the location in the application code that issued a database
+// operation as CodeFunctionTagKey, CodeFilepathTagKey and CodeLinenoTagKey
+// tags.
+// The location is the first function in the stack that is not part of the
+// database/sql, sqlmw or sqltracing packages or one of the passed skipPkgs.
+// skipPkgs are package import paths, e.g. "github.com/jmoiron/sqlx", to skip
+// database wrappers of the application.
+// The location is only resolved for operations that the application issues
+// directly. Operations on rows, prepared statements and transactions are
+// tagged with the location of the operation that returned them.
+func WithCallSite(skipPkgs ...string) Opt {
+	return func(drv *Interceptor) {
+		drv.callSiteResolver = newCallSiteResolver(skipPkgs)
+	}
+}
+
+// WithColumnNames can be passed when creating an Interceptor.
+// It records the column names of result sets as DBColumnsNamesTagKey tag on
+// query spans. If the driver supports it, the database type names of the
+// columns are recorded as DBColumnsTypesTagKey tag.
+func WithColumnNames() Opt {
+	return func(drv *Interceptor) {
+		drv.recordColumnNames = true
+	}
+}
+
+// WithQueryNamesFromComments can be passed when creating an Interceptor.
+// It extracts names of queries from comments in the query statements and
+// records them as DBQueryNameTagKey tag.
+// The first submatch of the first matching regular expression is used as
+// name. If no regular expressions are passed, DefaultQueryNameRegexps are
+// used.
+// The extracted names are cached per query, the size of the cache is set
+// via WithFingerprintCacheSize.
+func WithQueryNamesFromComments(res ...*regexp.Regexp) Opt {
+	return func(drv *Interceptor) {
+		if len(res) == 0 {
+			res = DefaultQueryNameRegexps
+		}
+
+		drv.queryNameRegexps = res
+	}
+}
+
+// WithQueryNameAsSpanName can be passed when creating an Interceptor.
+// It uses the name of a query as span name instead of the name of the
+// SQLOp, for operations that run a query that has a name.
+// Names are set via WithQueryName or WithQueryNamesFromComments.
+func WithQueryNameAsSpanName() Opt {
+	return func(drv *Interceptor) {
+		drv.queryNameAsSpanName = true
+	}
+}
+
+// WithMetrics can be passed when creating an Interceptor.
+// It reports all database operations to m, in addition to recording traces.
+func WithMetrics(m Metrics) Opt {
+	return func(drv *Interceptor) {
+		drv.metrics = m
+	}
+}
+
+// WithMetricsFingerprint can be passed when creating an Interceptor.
+// It reports the fingerprints of queries to Metrics, to allow recording
+// metrics per query. The fingerprints are cached like the ones of
+// WithQueryFingerprint.
+func WithMetricsFingerprint() Opt {
+	return func(drv *Interceptor) {
+		drv.metricsFingerprint = true
+	}
+}
+
+// WithErrorClassifier can be passed when creating an Interceptor.
+// It sets the function that is used to determine the error class of failed
+// operations that is reported to Metrics. The default is ClassifyError.
+func WithErrorClassifier(fn func(error) string) Opt {
+	return func(drv *Interceptor) {
+		drv.errorClassifier = fn
+	}
+}
+
+// WithPoolStatsCollector can be passed when creating an Interceptor.
+// It records the wait duration of the last sampling interval of c as
+// DBPoolWaitDurationTagKey tag on the spans of operations that take a
+// connection from the pool: OpSQLConnect, OpSQLTxBegin, OpSQLPrepare and
+// the exec and query operations.
+func WithPoolStatsCollector(c *PoolStatsCollector) Opt {
+	return func(drv *Interceptor) {
+		drv.poolStats = c
+	}
+}
+
+// WithRegistry can be passed when creating an Interceptor.
+// It registers all running operations in r.
+func WithRegistry(r *Registry) Opt {
+	return func(drv *Interceptor) {
+		drv.registry = r
+	}
+}
+
+// WithQueryStats can be passed when creating an Interceptor to aggregate
+// statistics about the executed queries in s.
+// To count the rows affected by exec operations, RowsAffected() is called on
+// all results.
+func WithQueryStats(s *QueryStats) Opt {
+	return func(t *Interceptor) {
+		t.queryStats = s
+	}
+}
+
+// WithDialect can be passed when creating an Interceptor.
+// It defines the SQL dialect that is used to parse queries.
+// The default is querynorm.DialectPostgres.
+func WithDialect(dialect querynorm.Dialect) Opt {
+	return func(t *Interceptor) {
+		t.dialect = dialect
+	}
+}
+
+// WithQueryFingerprint can be passed when creating an Interceptor.
+// It records the fingerprint of queries as DBQueryFingerprintTagKey tag.
+// The fingerprints are calculated with querynorm.Normalize.
+func WithQueryFingerprint() Opt {
+	return func(t *Interceptor) {
+		t.tagFingerprint = true
+	}
+}
+
+// WithFingerprintCacheSize can be passed when creating an Interceptor.
+// It sets the number of queries whose parsed forms are cached, to avoid
+// reparsing frequently executed queries. The default is
+// DefaultFingerprintCacheSize, 0 disables the cache.
+func WithFingerprintCacheSize(size int) Opt {
+	return func(t *Interceptor) {
+		t.fingerprintCacheSize = size
+	}
+}
+
+// WithOperationAndTables can be passed when creating an Interceptor.
+// It records the operation of query statements as DBOperationTagKey tag and
+// the referenced tables as DBSQLTableTagKey tag.
+// They are determined with querynorm.Analyze.
+func WithOperationAndTables() Opt {
+	return func(t *Interceptor) {
+		t.tagOperationAndTables = true
+	}
+}
+
+// WithNPlusOneDetector can be passed when creating an Interceptor to detect
+// N+1 query patterns with d.
+// The Tracer must implement SpanKeyer.
+func WithNPlusOneDetector(d *NPlusOneDetector) Opt {
+	return func(t *Interceptor) {
+		t.nPlusOne = d
+	}
+}
+
+// WithMaxStatementLength can be passed when creating an Interceptor.
+// Statements longer than maxLen bytes are truncated before they are recorded
+// as DBStatementTagKey tag, StatementTruncationMarker is appended to them.
+// The length and hash of the full statement are recorded as
+// DBStatementLengthTagKey and DBStatementHashTagKey tags.
+// The limit also applies to the statements shown by the Registry.
+func WithMaxStatementLength(maxLen int) Opt {
+	return func(t *Interceptor) {
+		t.maxStatementLength = maxLen
+	}
+}
+
+// WithStatementBatches can be passed when creating an Interceptor.
+// It splits queries that consist of multiple statements separated by
+// semicolons and records the number of statements as
+// DBStatementCountTagKey tag. The operation and tables of each statement
+// are recorded as span events, if the Span implements SpanEventer.
+// Batches that contain DDL and DML statements are tagged with
+// DBStatementMixedDDLDMLTagKey.
+func WithStatementBatches() Opt {
+	return func(t *Interceptor) {
+		t.tagBatches = true
+	}
+}
+
+// WithSQLCommenter can be passed when creating an Interceptor.
+// It appends comments with the trace context to queries, as configured by
+// c.
+func WithSQLCommenter(c *SQLCommenter) Opt {
+	return func(t *Interceptor) {
+		t.commenter = c
+	}
+}
+
+// WithOnConnect can be passed when creating an Interceptor.
+// fn is called for every new connection, see OnConnectFunc.
+func WithOnConnect(fn OnConnectFunc) Opt {
+	return func(t *Interceptor) {
+		t.onConnect = fn
+	}
+}
+
+// WithTimeout can be passed when creating an Interceptor.
+// It sets the maximum duration of op. If the deadline of the context of
+// the operation is later or unset, the operation is run with a context
+// that expires after timeout.
+// The timeout is recorded as DBTimeoutTagKey tag, when it expired the
+// operation is tagged with DBTimeoutExceededTagKey.
+//
+// Timeouts are supported for OpSQLConnect, OpSQLPing, OpSQLPrepare,
+// OpSQLConnExec, OpSQLConnQuery, OpSQLStmtExec, OpSQLStmtQuery and
+// OpSQLTxBegin. For queries the timeout includes reading the rows, for
+// OpSQLTxBegin it is the maximum lifetime of the transaction.
+// Timeouts are not applied to excluded operations.
+func WithTimeout(op SQLOp, timeout time.Duration) Opt {
+	return func(t *Interceptor) {
+		if t.timeouts.ops == nil {
+			t.timeouts.ops = map[SQLOp]time.Duration{}
+		}
+
+		t.timeouts.ops[op] = timeout
+	}
+}
+
+// WithQueryNameTimeout can be passed when creating an Interceptor.
+// It is like WithTimeout, but sets the timeout for queries with the name,
+// as recorded in the DBQueryNameTagKey tag.
+// It has precedence over timeouts set via WithTimeout.
+func WithQueryNameTimeout(name string, timeout time.Duration) Opt {
+	return func(t *Interceptor) {
+		if t.timeouts.queryNames == nil {
+			t.timeouts.queryNames = map[string]time.Duration{}
+		}
+
+		t.timeouts.queryNames[name] = timeout
+	}
+}
+
+// WithFingerprintTimeout can be passed when creating an Interceptor.
+// It is like WithTimeout, but sets the timeout for queries with the
+// fingerprint, as recorded in the DBQueryFingerprintTagKey tag.
+// It has precedence over timeouts set via WithTimeout and
+// WithQueryNameTimeout.
+func WithFingerprintTimeout(fingerprint string, timeout time.Duration) Opt {
+	return func(t *Interceptor) {
+		if t.timeouts.fingerprints == nil {
+			t.timeouts.fingerprints = map[string]time.Duration{}
+		}
+
+		t.timeouts.fingerprints[fingerprint] = timeout
+	}
+}
+
+// WithGuard can be passed when creating an Interceptor.
+// The queries of OpSQLPrepare, OpSQLConnExec and OpSQLConnQuery operations
+// are checked with the rules of guard. Queries of excluded operations are
+// not checked.
+func WithGuard(guard *Guard) Opt {
+	return func(t *Interceptor) {
+		t.guard = guard
+	}
+}
+
+// WithExplainer can be passed when creating an Interceptor.
+// The plans of slow queries are captured with explainer.
+func WithExplainer(explainer *Explainer) Opt {
+	return func(t *Interceptor) {
+		t.explainer = explainer
+	}
+}
+
+// WithConnLifetimeSpans can be passed when creating an Interceptor.
+// An OpSQLConnLifetime span is recorded for every connection, from its
+// creation until it is closed. It is a root span, because connections
+// outlive the operation that opened them, and tagged with the number of
+// operations that were run on the connection.
+// When the connection is reported as invalid, a ConnInvalidEventName event
+// is recorded on it.
+// OpSQLResetSession spans and OpSQLConnClose spans, as children of the
+// lifetime span, are also only recorded when the option is passed. Only
+// the OpSQLConnClose spans of invalid connections are always recorded.
+func WithConnLifetimeSpans() Opt {
+	return func(t *Interceptor) {
+		t.connLifetimeSpans = true
+	}
+}
@@ -0,0 +1,271 @@
+package sqltracing
+
+//go:generate go run gen_outerrows.go
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+)
+
+// The outer types wrap the driver, connections and statements returned by
+// sqlmw.WrapDriver.
+// sqlmw wraps all rows in a type that only implements driver.Rows, the
+// optional driver.Rows interfaces of the rows returned by the driver would
+// be hidden from database/sql. outerConn and outerStmt retrieve the
+// tracedRows via a rowsRef and return them wrapped in a type that
+// implements the same optional interfaces as the driver rows, it is
+// created by wrapOuterRows.
+//
+// outerConn and outerStmt implement the same interfaces as the sqlmw types
+// they wrap, all other methods are forwarded unchanged.
+// The types are only used by WrapDriver, when an Interceptor is passed
+// directly to sqlmw the optional interfaces of rows are not available.
+
+// sqlmwConn describes the interfaces that are implemented by the
+// connections of sqlmw.
+type sqlmwConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.Execer
+	driver.ExecerContext
+	driver.Pinger
+	driver.Queryer
+	driver.QueryerContext
+	driver.SessionResetter
+	driver.NamedValueChecker
+	driver.Validator
+}
+
+// sqlmwStmt describes the interfaces that are implemented by the statements
+// of sqlmw.
+type sqlmwStmt interface {
+	driver.Stmt
+	driver.StmtExecContext
+	driver.StmtQueryContext
+	driver.ColumnConverter
+	driver.NamedValueChecker
+}
+
+type outerDriver struct {
+	driver.Driver
+	icp *Interceptor
+}
+
+type outerConnector struct {
+	driver.Connector
+	driver *outerDriver
+}
+
+// outerConn wraps a connection of sqlmw.
+// conn is the tracedConn that is wrapped by sqlmw, it is retrieved via a
+// connRef when the connection is created. It is nil for connections that
+// were opened via outerDriver.Open.
+type outerConn struct {
+	sqlmwConn
+	conn *tracedConn
+	icp  *Interceptor
+}
+
+type outerStmt struct {
+	sqlmwStmt
+	icp *Interceptor
+}
+
+// outerRows wraps rows returned by sqlmw. traced are the rows returned by
+// the Interceptor, that sqlmw wraps.
+type outerRows struct {
+	driver.Rows
+	traced *tracedRows
+	icp    *Interceptor
+}
+
+// Compile time validation that our types implement the expected interfaces
+var (
+	_ driver.Driver        = &outerDriver{}
+	_ driver.DriverContext = &outerDriver{}
+	_ driver.Connector     = &outerConnector{}
+	_ sqlmwConn            = &outerConn{}
+	_ sqlmwStmt            = &outerStmt{}
+	_ driver.Rows          = &outerRows{}
+)
+
+// wrapConn wraps conn in an outerConn, if it is a connection of sqlmw.
+func (d *outerDriver) wrapConn(conn driver.Conn, tracedConn *tracedConn) driver.Conn {
+	if c, ok := conn.(sqlmwConn); ok {
+		return &outerConn{sqlmwConn: c, conn: tracedConn, icp: d.icp}
+	}
+
+	return conn
+}
+
+func (d *outerDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.wrapConn(conn, nil), nil
+}
+
+func (d *outerDriver) OpenConnector(name string) (driver.Connector, error) {
+	var connector driver.Connector
+
+	if driverCtx, ok := d.Driver.(driver.DriverContext); ok {
+		var err error
+
+		connector, err = driverCtx.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		connector = dsnConnector{dsn: name, driver: d.Driver}
+	}
+
+	return &outerConnector{Connector: connector, driver: d}, nil
+}
+
+func (c *outerConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	// ConnectorConnect stores the created tracedConn in the connRef
+	ctx, connRef := withConnRef(ctx)
+
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.driver.wrapConn(conn, connRef.conn), nil
+}
+
+func (c *outerConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// dsnConnector is used as connector of drivers that do not implement
+// driver.DriverContext.
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+func (c *outerConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.sqlmwConn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if s, ok := stmt.(sqlmwStmt); ok {
+		return &outerStmt{sqlmwStmt: s, icp: c.icp}, nil
+	}
+
+	return stmt, nil
+}
+
+// ExecContext returns driver.ErrSkip if the wrapped connection does not
+// support exec operations. sqlmw calls the ExecContext method of the
+// connection in that case, which is not implemented.
+// database/sql prepares the statement and executes it instead, the
+// skipped operation is not recorded.
+func (c *outerConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if !c.conn.supportsExec() {
+		return nil, driver.ErrSkip
+	}
+
+	return c.sqlmwConn.ExecContext(ctx, query, args)
+}
+
+// QueryContext returns driver.ErrSkip if the wrapped connection does not
+// support query operations, like ExecContext.
+func (c *outerConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if !c.conn.supportsQuery() {
+		return nil, driver.ErrSkip
+	}
+
+	ctx, ref := withRowsRef(ctx)
+
+	rows, err := c.sqlmwConn.QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return newOuterRows(rows, ref.rows, c.icp), nil
+}
+
+func (s *outerStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	ctx, ref := withRowsRef(ctx)
+
+	rows, err := s.sqlmwStmt.QueryContext(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return newOuterRows(rows, ref.rows, s.icp), nil
+}
+
+// newOuterRows wraps rows, that were returned by sqlmw, via
+// wrapOuterRows. If traced is nil, rows is returned.
+func newOuterRows(rows driver.Rows, traced *tracedRows, icp *Interceptor) driver.Rows {
+	if traced == nil {
+		return rows
+	}
+
+	return wrapOuterRows(&outerRows{
+		Rows:   rows,
+		traced: traced,
+		icp:    icp,
+	})
+}
+
+// The outerRows* types implement one optional driver.Rows interface each
+// by forwarding to the driver rows. They are embedded by the types created
+// by wrapOuterRows, they must only be used when the driver rows implement
+// the interface.
+
+type outerRowsNextResultSet struct{ r *outerRows }
+
+func (w outerRowsNextResultSet) HasNextResultSet() bool {
+	return w.r.traced.Rows.(driver.RowsNextResultSet).HasNextResultSet()
+}
+
+func (w outerRowsNextResultSet) NextResultSet() error {
+	return w.r.icp.rowsNextResultSet(w.r.traced, w.r.traced.Rows.(driver.RowsNextResultSet))
+}
+
+type outerRowsColumnTypeScanType struct{ r *outerRows }
+
+func (w outerRowsColumnTypeScanType) ColumnTypeScanType(index int) reflect.Type {
+	return w.r.traced.Rows.(driver.RowsColumnTypeScanType).ColumnTypeScanType(index)
+}
+
+type outerRowsColumnTypeDatabaseTypeName struct{ r *outerRows }
+
+func (w outerRowsColumnTypeDatabaseTypeName) ColumnTypeDatabaseTypeName(index int) string {
+	return w.r.traced.Rows.(driver.RowsColumnTypeDatabaseTypeName).ColumnTypeDatabaseTypeName(index)
+}
+
+type outerRowsColumnTypeLength struct{ r *outerRows }
+
+func (w outerRowsColumnTypeLength) ColumnTypeLength(index int) (int64, bool) {
+	return w.r.traced.Rows.(driver.RowsColumnTypeLength).ColumnTypeLength(index)
+}
+
+type outerRowsColumnTypeNullable struct{ r *outerRows }
+
+func (w outerRowsColumnTypeNullable) ColumnTypeNullable(index int) (bool, bool) {
+	return w.r.traced.Rows.(driver.RowsColumnTypeNullable).ColumnTypeNullable(index)
+}
+
+type outerRowsColumnTypePrecisionScale struct{ r *outerRows }
+
+func (w outerRowsColumnTypePrecisionScale) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
+	return w.r.traced.Rows.(driver.RowsColumnTypePrecisionScale).ColumnTypePrecisionScale(index)
+}
@@ -0,0 +1,485 @@
+// Code generated by gen_outerrows.go; DO NOT EDIT.
+
+package sqltracing
+
+import "database/sql/driver"
+
+// wrapOuterRows returns r wrapped in a type that implements the optional
+// driver.Rows interfaces that are implemented by the driver rows of r.
+// If they implement none, r is returned.
+func wrapOuterRows(r *outerRows) driver.Rows {
+	var implemented int
+
+	if _, ok := r.traced.Rows.(driver.RowsNextResultSet); ok {
+		implemented |= 1 << 0
+	}
+
+	if _, ok := r.traced.Rows.(driver.RowsColumnTypeScanType); ok {
+		implemented |= 1 << 1
+	}
+
+	if _, ok := r.traced.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		implemented |= 1 << 2
+	}
+
+	if _, ok := r.traced.Rows.(driver.RowsColumnTypeLength); ok {
+		implemented |= 1 << 3
+	}
+
+	if _, ok := r.traced.Rows.(driver.RowsColumnTypeNullable); ok {
+		implemented |= 1 << 4
+	}
+
+	if _, ok := r.traced.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		implemented |= 1 << 5
+	}
+
+	switch implemented {
+	case 1:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+		}{r, outerRowsNextResultSet{r}}
+	case 2:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeScanType
+		}{r, outerRowsColumnTypeScanType{r}}
+	case 3:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeScanType
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeScanType{r}}
+	case 4:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeDatabaseTypeName
+		}{r, outerRowsColumnTypeDatabaseTypeName{r}}
+	case 5:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeDatabaseTypeName
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeDatabaseTypeName{r}}
+	case 6:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeDatabaseTypeName
+		}{r, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeDatabaseTypeName{r}}
+	case 7:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeDatabaseTypeName
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeDatabaseTypeName{r}}
+	case 8:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeLength
+		}{r, outerRowsColumnTypeLength{r}}
+	case 9:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeLength
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeLength{r}}
+	case 10:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeLength
+		}{r, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeLength{r}}
+	case 11:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeLength
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeLength{r}}
+	case 12:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeLength
+		}{r, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeLength{r}}
+	case 13:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeLength
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeLength{r}}
+	case 14:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeLength
+		}{r, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeLength{r}}
+	case 15:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeLength
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeLength{r}}
+	case 16:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeNullable
+		}{r, outerRowsColumnTypeNullable{r}}
+	case 17:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeNullable
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeNullable{r}}
+	case 18:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeNullable
+		}{r, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeNullable{r}}
+	case 19:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeNullable
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeNullable{r}}
+	case 20:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeNullable
+		}{r, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeNullable{r}}
+	case 21:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeNullable
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeNullable{r}}
+	case 22:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeNullable
+		}{r, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeNullable{r}}
+	case 23:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeNullable
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeNullable{r}}
+	case 24:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeLength
+			outerRowsColumnTypeNullable
+		}{r, outerRowsColumnTypeLength{r}, outerRowsColumnTypeNullable{r}}
+	case 25:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeLength
+			outerRowsColumnTypeNullable
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypeNullable{r}}
+	case 26:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeLength
+			outerRowsColumnTypeNullable
+		}{r, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypeNullable{r}}
+	case 27:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeLength
+			outerRowsColumnTypeNullable
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypeNullable{r}}
+	case 28:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeLength
+			outerRowsColumnTypeNullable
+		}{r, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypeNullable{r}}
+	case 29:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeLength
+			outerRowsColumnTypeNullable
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypeNullable{r}}
+	case 30:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeLength
+			outerRowsColumnTypeNullable
+		}{r, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypeNullable{r}}
+	case 31:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeLength
+			outerRowsColumnTypeNullable
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypeNullable{r}}
+	case 32:
+		return struct {
+			*outerRows
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsColumnTypePrecisionScale{r}}
+	case 33:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 34:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsColumnTypeScanType{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 35:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeScanType{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 36:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 37:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 38:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 39:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 40:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeLength
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsColumnTypeLength{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 41:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeLength
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 42:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeLength
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 43:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeLength
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 44:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeLength
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 45:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeLength
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 46:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeLength
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 47:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeLength
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 48:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeNullable
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsColumnTypeNullable{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 49:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeNullable
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeNullable{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 50:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeNullable
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeNullable{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 51:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeNullable
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeNullable{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 52:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeNullable
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeNullable{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 53:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeNullable
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeNullable{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 54:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeNullable
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeNullable{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 55:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeNullable
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeNullable{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 56:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeLength
+			outerRowsColumnTypeNullable
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsColumnTypeLength{r}, outerRowsColumnTypeNullable{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 57:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeLength
+			outerRowsColumnTypeNullable
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypeNullable{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 58:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeLength
+			outerRowsColumnTypeNullable
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypeNullable{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 59:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeLength
+			outerRowsColumnTypeNullable
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypeNullable{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 60:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeLength
+			outerRowsColumnTypeNullable
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypeNullable{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 61:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeLength
+			outerRowsColumnTypeNullable
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypeNullable{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 62:
+		return struct {
+			*outerRows
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeLength
+			outerRowsColumnTypeNullable
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypeNullable{r}, outerRowsColumnTypePrecisionScale{r}}
+	case 63:
+		return struct {
+			*outerRows
+			outerRowsNextResultSet
+			outerRowsColumnTypeScanType
+			outerRowsColumnTypeDatabaseTypeName
+			outerRowsColumnTypeLength
+			outerRowsColumnTypeNullable
+			outerRowsColumnTypePrecisionScale
+		}{r, outerRowsNextResultSet{r}, outerRowsColumnTypeScanType{r}, outerRowsColumnTypeDatabaseTypeName{r}, outerRowsColumnTypeLength{r}, outerRowsColumnTypeNullable{r}, outerRowsColumnTypePrecisionScale{r}}
+	default:
+		return r
+	}
+}
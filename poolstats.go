@@ -0,0 +1,161 @@
+package sqltracing
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DBPoolWaitDurationTagKey is the name of the tracing tag that contains the
+// time that was spent waiting for free connections in the connection pool,
+// during the last sampling interval of the PoolStatsCollector.
+const DBPoolWaitDurationTagKey = "db.pool.wait_duration_delta"
+
+// DefaultPoolStatsInterval is the default sampling interval of a
+// PoolStatsCollector.
+const DefaultPoolStatsInterval = 10 * time.Second
+
+// PoolMetrics can be implemented by Metrics implementations to record
+// connection pool statistics that are sampled by a PoolStatsCollector.
+type PoolMetrics interface {
+	// PoolStats is called with every sample of the pool statistics.
+	PoolStats(stats *PoolStats)
+}
+
+// PoolStats are the statistics of a database connection pool.
+// The embedded sql.DBStats contain the current values, the fields with the
+// Delta suffix contain the difference to the previous sample.
+type PoolStats struct {
+	sql.DBStats
+	// Name identifies the pool, it is set via WithPoolName.
+	Name string
+
+	WaitCountDelta         int64
+	WaitDurationDelta      time.Duration
+	MaxIdleClosedDelta     int64
+	MaxIdleTimeClosedDelta int64
+	MaxLifetimeClosedDelta int64
+}
+
+// PoolStatsCollector periodically samples the connection pool statistics of
+// a sql.DB and reports them to PoolMetrics.
+// The connection pool is managed by database/sql, operations that wait for
+// a free connection are never seen by the Interceptor.
+// When the collector is passed to an Interceptor via WithPoolStatsCollector,
+// the wait duration of the last sampling interval is recorded on the spans
+// of operations that take a connection from the pool.
+type PoolStatsCollector struct {
+	name     string
+	interval time.Duration
+	metrics  PoolMetrics
+
+	mu   sync.Mutex
+	prev sql.DBStats
+
+	// waitDurationDelta is the WaitDurationDelta of the last sample in
+	// nanoseconds, -1 if no sample was taken yet.
+	waitDurationDelta int64
+}
+
+// PoolStatsOpt is a type for options for the PoolStatsCollector.
+type PoolStatsOpt func(*PoolStatsCollector)
+
+// WithPoolName sets the name of the pool, that is reported as
+// PoolStats.Name.
+func WithPoolName(name string) PoolStatsOpt {
+	return func(c *PoolStatsCollector) {
+		c.name = name
+	}
+}
+
+// WithPoolStatsInterval sets the interval in which the statistics are
+// sampled by PoolStatsCollector.Run. The default is
+// DefaultPoolStatsInterval, it is also used if d is not positive.
+func WithPoolStatsInterval(d time.Duration) PoolStatsOpt {
+	return func(c *PoolStatsCollector) {
+		if d <= 0 {
+			d = DefaultPoolStatsInterval
+		}
+
+		c.interval = d
+	}
+}
+
+// WithPoolMetrics sets the metrics implementation the samples are reported
+// to.
+func WithPoolMetrics(m PoolMetrics) PoolStatsOpt {
+	return func(c *PoolStatsCollector) {
+		c.metrics = m
+	}
+}
+
+// NewPoolStatsCollector returns a new PoolStatsCollector.
+func NewPoolStatsCollector(opts ...PoolStatsOpt) *PoolStatsCollector {
+	c := PoolStatsCollector{
+		interval:          DefaultPoolStatsInterval,
+		waitDurationDelta: -1,
+	}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return &c
+}
+
+// Run samples the statistics of db in the configured interval, until ctx
+// is canceled.
+func (c *PoolStatsCollector) Run(ctx context.Context, db *sql.DB) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	c.Collect(db)
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Collect(db)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Collect takes a single sample of the statistics of db and reports it.
+// The deltas are calculated to the previous invocation of Collect.
+func (c *PoolStatsCollector) Collect(db *sql.DB) {
+	cur := db.Stats()
+
+	c.mu.Lock()
+	stats := PoolStats{
+		DBStats:                cur,
+		Name:                   c.name,
+		WaitCountDelta:         cur.WaitCount - c.prev.WaitCount,
+		WaitDurationDelta:      cur.WaitDuration - c.prev.WaitDuration,
+		MaxIdleClosedDelta:     cur.MaxIdleClosed - c.prev.MaxIdleClosed,
+		MaxIdleTimeClosedDelta: cur.MaxIdleTimeClosed - c.prev.MaxIdleTimeClosed,
+		MaxLifetimeClosedDelta: cur.MaxLifetimeClosed - c.prev.MaxLifetimeClosed,
+	}
+	c.prev = cur
+	c.mu.Unlock()
+
+	atomic.StoreInt64(&c.waitDurationDelta, int64(stats.WaitDurationDelta))
+
+	if c.metrics != nil {
+		c.metrics.PoolStats(&stats)
+	}
+}
+
+// WaitDurationDelta returns the WaitDurationDelta of the last sample.
+// If no sample was taken yet, false is returned.
+func (c *PoolStatsCollector) WaitDurationDelta() (time.Duration, bool) {
+	d := atomic.LoadInt64(&c.waitDurationDelta)
+	if d < 0 {
+		return 0, false
+	}
+
+	return time.Duration(d), true
+}
@@ -0,0 +1,196 @@
+package sqltracing
+
+import (
+	"container/list"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/simplesurance/sqltracing/querynorm"
+)
+
+// DefaultFingerprintCacheSize is the default number of queries whose
+// parsed forms are cached.
+const DefaultFingerprintCacheSize = 1024
+
+// maxCachedQueryLen is the maximum length of queries that are stored in the
+// query cache. Longer queries are usually generated and rarely
+// repeated, caching them would only waste memory.
+const maxCachedQueryLen = 16 * 1024
+
+// parsedQuery is the result of normalizing and analyzing a query with
+// querynorm.
+type parsedQuery struct {
+	text        string
+	fingerprint uint64
+	operation   string
+	tables      []string
+	// batch describes the statements of queries that consist of multiple
+	// statements, it is nil for single statements.
+	batch []*querynorm.Analysis
+	// name is the query name that is extracted from the comments of the
+	// query.
+	name string
+	// statements are the statements of the query, they are only set if
+	// the queryCache splits statements.
+	statements []*parsedStatement
+}
+
+// parsedStatement is a statement of a query, as it is checked by the Guard.
+type parsedStatement struct {
+	text string
+	// tokens are the tokens of the statement without comments.
+	tokens   []querynorm.Token
+	analysis *querynorm.Analysis
+}
+
+func (q *parsedQuery) fingerprintString() string {
+	return fmt.Sprintf("%016x", q.fingerprint)
+}
+
+// queryCache is a LRU cache for parsed queries.
+type queryCache struct {
+	dialect         querynorm.Dialect
+	size            int
+	nameRegexps     []*regexp.Regexp
+	splitStatements bool
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+type queryCacheEntry struct {
+	query  string
+	parsed *parsedQuery
+}
+
+func newQueryCache(dialect querynorm.Dialect, size int, nameRegexps []*regexp.Regexp, splitStatements bool) *queryCache {
+	return &queryCache{
+		dialect:         dialect,
+		size:            size,
+		nameRegexps:     nameRegexps,
+		splitStatements: splitStatements,
+		lru:             list.New(),
+		items:           map[string]*list.Element{},
+	}
+}
+
+// get returns the parsed query.
+func (c *queryCache) get(query string) *parsedQuery {
+	if c.size <= 0 || len(query) > maxCachedQueryLen {
+		return c.parse(query)
+	}
+
+	c.mu.Lock()
+	if elem, exist := c.items[query]; exist {
+		c.lru.MoveToFront(elem)
+		c.mu.Unlock()
+
+		return elem.Value.(*queryCacheEntry).parsed
+	}
+	c.mu.Unlock()
+
+	parsed := c.parse(query)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exist := c.items[query]; exist {
+		return parsed
+	}
+
+	c.items[query] = c.lru.PushFront(&queryCacheEntry{
+		query:  query,
+		parsed: parsed,
+	})
+
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*queryCacheEntry).query)
+	}
+
+	return parsed
+}
+
+// name returns the query name that is extracted from the comments of
+// query with the nameRegexps.
+// The regular expressions are only run directly on queries that are not
+// cached, otherwise the name is extracted once when the query is parsed.
+func (c *queryCache) name(query string) string {
+	if c.size <= 0 || len(query) > maxCachedQueryLen {
+		return extractQueryName(query, c.nameRegexps)
+	}
+
+	return c.get(query).name
+}
+
+func (c *queryCache) parse(query string) *parsedQuery {
+	tokens := querynorm.Tokenize(query, c.dialect)
+	text, fp := querynorm.NormalizeTokens(tokens)
+	analysis := querynorm.AnalyzeTokens(tokens)
+
+	parsed := parsedQuery{
+		text:        text,
+		fingerprint: fp,
+		operation:   analysis.Operation,
+		tables:      analysis.Tables,
+		name:        extractQueryName(query, c.nameRegexps),
+	}
+
+	for _, tok := range tokens {
+		if tok.Kind == querynorm.TokenPunctuation && tok.Text == ";" {
+			parsed.batch = analyzeBatch(query, c.dialect)
+			break
+		}
+	}
+
+	if c.splitStatements {
+		parsed.statements = parseStatements(query, c.dialect)
+	}
+
+	return &parsed
+}
+
+// analyzeBatch analyzes the statements of query. If it only contains a
+// single statement, nil is returned.
+func analyzeBatch(query string, dialect querynorm.Dialect) []*querynorm.Analysis {
+	stmts := querynorm.Split(query, dialect)
+	if len(stmts) < 2 {
+		return nil
+	}
+
+	result := make([]*querynorm.Analysis, 0, len(stmts))
+	for _, stmt := range stmts {
+		result = append(result, querynorm.Analyze(stmt, dialect))
+	}
+
+	return result
+}
+
+// parseStatements splits query into its statements and tokenizes and
+// analyzes them.
+func parseStatements(query string, dialect querynorm.Dialect) []*parsedStatement {
+	stmts := querynorm.Split(query, dialect)
+	result := make([]*parsedStatement, 0, len(stmts))
+
+	for _, stmt := range stmts {
+		allTokens := querynorm.Tokenize(stmt, dialect)
+		tokens := make([]querynorm.Token, 0, len(allTokens))
+
+		for _, tok := range allTokens {
+			if tok.Kind != querynorm.TokenComment {
+				tokens = append(tokens, tok)
+			}
+		}
+
+		result = append(result, &parsedStatement{
+			text:     stmt,
+			tokens:   tokens,
+			analysis: querynorm.AnalyzeTokens(tokens),
+		})
+	}
+
+	return result
+}
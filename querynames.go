@@ -0,0 +1,64 @@
+package sqltracing
+
+import (
+	"context"
+	"regexp"
+)
+
+// DBQueryNameTagKey is the name of the tracing tag that contains the
+// application defined name of a query.
+const DBQueryNameTagKey = "db.query.name"
+
+// DefaultQueryNameRegexps are the regular expressions that are used to
+// extract query names from comments when WithQueryNamesFromComments is passed
+// without arguments.
+// They match sqlc annotations like "-- name: GetUserByID :one" and comments
+// like "/* name=GetUserByID */".
+var DefaultQueryNameRegexps = []*regexp.Regexp{
+	regexp.MustCompile(`--\s*name:\s*([\w.-]+)`),
+	regexp.MustCompile(`/\*\s*name\s*=\s*([\w.-]+)\s*\*/`),
+}
+
+type queryNameCtxKey struct{}
+
+// WithQueryName returns a new context that contains name as query name.
+// Queries that are run with the context are tagged with the name as
+// DBQueryNameTagKey tag.
+// The name has precedence over names extracted from query comments.
+func WithQueryName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, queryNameCtxKey{}, name)
+}
+
+func queryNameFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(queryNameCtxKey{}).(string)
+	return name
+}
+
+// queryName returns the name of the query.
+// The name is retrieved from ctx, if it does not exist it is extracted from
+// the comments in the query.
+// fromCtx is true if the name was found in the context.
+func (t *Interceptor) queryName(ctx context.Context, query string) (name string, fromCtx bool) {
+	if name := queryNameFromContext(ctx); name != "" {
+		return name, true
+	}
+
+	if query == "" || len(t.queryNameRegexps) == 0 {
+		return "", false
+	}
+
+	return t.queryCache.name(query), false
+}
+
+// extractQueryName returns the first name that one of the regular
+// expressions extracts from query.
+func extractQueryName(query string, res []*regexp.Regexp) string {
+	for _, re := range res {
+		match := re.FindStringSubmatch(query)
+		if len(match) > 1 && match[1] != "" {
+			return match[1]
+		}
+	}
+
+	return ""
+}
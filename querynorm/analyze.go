@@ -0,0 +1,443 @@
+package querynorm
+
+import "strings"
+
+// Analysis describes the statement of a query.
+type Analysis struct {
+	// Operation is the uppercase keyword of the statement, e.g. SELECT,
+	// INSERT or CREATE. For statements with common table expressions it
+	// is the operation of the main statement.
+	Operation string
+	// Tables are the names of the tables that are referenced in the
+	// statement, in order of their first occurrence.
+	// Quotes are removed from the names, schema-qualified names are
+	// returned with their schema. Names of common table expressions are
+	// not included.
+	Tables []string
+}
+
+// Analyze determines the operation and referenced tables of query.
+// It is tolerant, for invalid or unsupported statements the result might be
+// incomplete.
+func Analyze(query string, dialect Dialect) *Analysis {
+	return AnalyzeTokens(Tokenize(query, dialect))
+}
+
+// AnalyzeTokens is like Analyze but operates on the tokens returned by
+// Tokenize.
+func AnalyzeTokens(tokens []Token) *Analysis {
+	a := analyzer{
+		tokens: make([]Token, 0, len(tokens)),
+		ctes:   map[string]struct{}{},
+		seen:   map[string]struct{}{},
+	}
+
+	for _, tok := range tokens {
+		if tok.Kind != TokenComment {
+			a.tokens = append(a.tokens, tok)
+		}
+	}
+
+	return a.run()
+}
+
+// IsDDL returns true if operation is a data definition statement, like
+// CREATE, ALTER or DROP.
+func IsDDL(operation string) bool {
+	switch operation {
+	case "CREATE", "ALTER", "DROP", "TRUNCATE", "RENAME", "COMMENT", "GRANT", "REVOKE":
+		return true
+	default:
+		return false
+	}
+}
+
+// IsDML returns true if operation is a data manipulation statement, like
+// SELECT, INSERT, UPDATE or DELETE.
+func IsDML(operation string) bool {
+	switch operation {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "MERGE", "REPLACE", "VALUES", "TABLE":
+		return true
+	default:
+		return false
+	}
+}
+
+type analyzer struct {
+	tokens []Token
+	ctes   map[string]struct{}
+	seen   map[string]struct{}
+	result Analysis
+}
+
+func (a *analyzer) run() *Analysis {
+	a.result.Operation = a.operation()
+
+	// fromDepths contains the parenthesis depths of the FROM clauses that
+	// are currently open, to continue their table lists after joins, e.g.
+	// "FROM a JOIN b ON a.id = b.id, c"
+	var fromDepths []int
+
+	// parens contains per open parenthesis whether it encloses the
+	// arguments of a function call. Keywords in them are not clauses,
+	// e.g. the FROM in "EXTRACT(EPOCH FROM ts)".
+	var parens []bool
+
+	for i := 0; i < len(a.tokens); i++ {
+		switch {
+		case a.isPunct(i, "("):
+			parens = append(parens, a.isFunctionCall(i))
+			continue
+
+		case a.isPunct(i, ")"):
+			if len(parens) > 0 {
+				parens = parens[:len(parens)-1]
+			}
+
+			for len(fromDepths) > 0 && fromDepths[len(fromDepths)-1] > len(parens) {
+				fromDepths = fromDepths[:len(fromDepths)-1]
+			}
+
+			continue
+
+		case a.isPunct(i, ","):
+			if len(fromDepths) > 0 && fromDepths[len(fromDepths)-1] == len(parens) {
+				a.tableList(i+1, true)
+			}
+
+			continue
+
+		case len(parens) > 0 && parens[len(parens)-1]:
+			continue
+		}
+
+		depth := len(parens)
+
+		switch a.keyword(i) {
+		case "FROM":
+			if len(fromDepths) == 0 || fromDepths[len(fromDepths)-1] != depth {
+				fromDepths = append(fromDepths, depth)
+			}
+
+			a.tableList(i+1, true)
+
+		case "JOIN":
+			a.tableList(i+1, true)
+
+		case "WHERE", "GROUP", "ORDER", "HAVING", "LIMIT", "OFFSET", "UNION",
+			"INTERSECT", "EXCEPT", "WINDOW", "FETCH", "FOR", "RETURNING", "SET":
+			// the clauses end the FROM clause
+			if len(fromDepths) > 0 && fromDepths[len(fromDepths)-1] == depth {
+				fromDepths = fromDepths[:len(fromDepths)-1]
+			}
+
+		case "INTO", "TABLE":
+			a.tableList(i+1, false)
+
+		case "USING":
+			// DELETE ... USING t and MERGE ... USING t, JOIN ...
+			// USING (col) is skipped because it is followed by a
+			// parenthesis
+			a.tableList(i+1, true)
+
+		case "UPDATE":
+			// ON CONFLICT ... DO UPDATE, ON DUPLICATE KEY UPDATE and
+			// FOR UPDATE do not reference tables
+			switch a.keyword(i - 1) {
+			case "DO", "KEY", "FOR":
+				continue
+			}
+
+			a.tableList(i+1, false)
+		}
+	}
+
+	return &a.result
+}
+
+// keyword returns the uppercase text of the word token at index i, if it
+// is a keyword. Otherwise an empty string is returned.
+func (a *analyzer) keyword(i int) string {
+	if i < 0 || i >= len(a.tokens) || a.tokens[i].Kind != TokenWord {
+		return ""
+	}
+
+	upper := strings.ToUpper(a.tokens[i].Text)
+	if !IsKeyword(upper) {
+		return ""
+	}
+
+	return upper
+}
+
+// isFunctionCall returns true if the parenthesis at index i encloses the
+// arguments of a function call. It is preceded by a function name and does
+// not enclose a subquery, like in "ARRAY(SELECT id FROM t)".
+func (a *analyzer) isFunctionCall(i int) bool {
+	if i == 0 {
+		return false
+	}
+
+	prev := a.tokens[i-1]
+
+	switch {
+	case prev.Kind == TokenQuotedIdentifier:
+	case prev.Kind == TokenWord && (a.keyword(i-1) == "" || isFunctionKeyword(a.keyword(i-1))):
+	default:
+		return false
+	}
+
+	switch a.keyword(i + 1) {
+	case "SELECT", "WITH", "VALUES":
+		return false
+	default:
+		return true
+	}
+}
+
+func (a *analyzer) isPunct(i int, text string) bool {
+	return i >= 0 && i < len(a.tokens) && a.tokens[i].Kind == TokenPunctuation && a.tokens[i].Text == text
+}
+
+// operation returns the operation of the statement and records the names
+// of common table expressions.
+func (a *analyzer) operation() string {
+	i := 0
+
+	// parenthesized statements, e.g. (SELECT 1) UNION (SELECT 2)
+	for a.isPunct(i, "(") {
+		i++
+	}
+
+	if a.keyword(i) != "WITH" {
+		return a.keyword(i)
+	}
+
+	i++
+	if a.keyword(i) == "RECURSIVE" {
+		i++
+	}
+
+	for i < len(a.tokens) {
+		// name [(columns)] AS [NOT] [MATERIALIZED] (statement)
+		a.ctes[strings.ToLower(unquote(a.tokens[i].Text))] = struct{}{}
+		i++
+
+		if a.isPunct(i, "(") {
+			i = a.closingParen(i) + 1
+		}
+
+		for a.keyword(i) == "AS" || a.keyword(i) == "NOT" || a.keyword(i) == "MATERIALIZED" {
+			i++
+		}
+
+		if !a.isPunct(i, "(") {
+			return ""
+		}
+
+		i = a.closingParen(i) + 1
+
+		if !a.isPunct(i, ",") {
+			break
+		}
+
+		i++
+	}
+
+	for a.isPunct(i, "(") {
+		i++
+	}
+
+	return a.keyword(i)
+}
+
+// tokenClosingParen returns the index of the parenthesis that closes the
+// one at tokens[start]. If tokens[start] is not an opening parenthesis or
+// it is not closed, -1 is returned.
+func tokenClosingParen(tokens []Token, start int) int {
+	return matchParen(len(tokens), func(i int) string {
+		if tokens[i].Kind != TokenPunctuation {
+			return ""
+		}
+
+		return tokens[i].Text
+	}, start)
+}
+
+// closingParen returns the index of the parenthesis that closes the one at
+// index start. If it is not closed, the index after the last token is
+// returned.
+func (a *analyzer) closingParen(start int) int {
+	if end := tokenClosingParen(a.tokens, start); end != -1 {
+		return end
+	}
+
+	return len(a.tokens)
+}
+
+// SelectsOnlyAggregates returns true if tokens are a SELECT statement in
+// which every expression of the select list is a call of an aggregate
+// function, like "SELECT COUNT(*), MAX(id) AS m FROM t".
+func SelectsOnlyAggregates(tokens []Token) bool {
+	if len(tokens) == 0 || !isKeywordToken(tokens[0], "SELECT") {
+		return false
+	}
+
+	i := 1
+	for {
+		if i >= len(tokens) || !isKeywordToken(tokens[i], "COUNT", "SUM", "AVG", "MIN", "MAX") {
+			return false
+		}
+
+		i = tokenClosingParen(tokens, i+1)
+		if i == -1 {
+			return false
+		}
+
+		i++
+
+		// alias
+		if i < len(tokens) && isKeywordToken(tokens[i], "AS") {
+			i++
+		}
+
+		if i < len(tokens) && !isKeywordToken(tokens[i], "FROM") &&
+			(tokens[i].Kind == TokenWord || tokens[i].Kind == TokenQuotedIdentifier) {
+			i++
+		}
+
+		if i >= len(tokens) || tokens[i].Kind != TokenPunctuation || tokens[i].Text != "," {
+			return i < len(tokens) && isKeywordToken(tokens[i], "FROM")
+		}
+
+		i++
+	}
+}
+
+// isKeywordToken returns true if tok is a word that matches one of the
+// uppercase keywords case-insensitively.
+func isKeywordToken(tok Token, keywords ...string) bool {
+	if tok.Kind != TokenWord {
+		return false
+	}
+
+	upper := strings.ToUpper(tok.Text)
+	for _, kw := range keywords {
+		if upper == kw {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tableList records the table names in the comma separated list that
+// starts at index i. If isFrom is true, the list is a FROM or JOIN clause
+// in which names that are followed by a parenthesis are function calls.
+func (a *analyzer) tableList(i int, isFrom bool) {
+	for {
+		switch a.keyword(i) {
+		case "ONLY", "LATERAL":
+			i++
+		case "IF":
+			// IF NOT EXISTS / IF EXISTS
+			for a.keyword(i) == "IF" || a.keyword(i) == "NOT" || a.keyword(i) == "EXISTS" {
+				i++
+			}
+		}
+
+		name, next, ok := a.name(i)
+		if !ok {
+			return
+		}
+
+		if isFrom && a.isPunct(next, "(") {
+			// table function
+			next = a.closingParen(next) + 1
+		} else {
+			a.addTable(name)
+		}
+
+		if !isFrom {
+			return
+		}
+
+		// alias
+		if a.keyword(next) == "AS" {
+			next++
+		}
+
+		if next < len(a.tokens) && a.keyword(next) == "" &&
+			(a.tokens[next].Kind == TokenWord || a.tokens[next].Kind == TokenQuotedIdentifier) {
+			next++
+		}
+
+		if !a.isPunct(next, ",") {
+			return
+		}
+
+		i = next + 1
+	}
+}
+
+// name reads a possibly schema-qualified identifier at index i.
+// It returns the name and the index of the token after it.
+// Keywords are not names, except the ones that are commonly used as table
+// names, like COMMENT.
+func (a *analyzer) name(i int) (string, int, bool) {
+	var parts []string
+
+	for i < len(a.tokens) {
+		tok := a.tokens[i]
+
+		switch {
+		case tok.Kind == TokenQuotedIdentifier:
+			parts = append(parts, unquote(tok.Text))
+		case tok.Kind == TokenWord && (len(parts) > 0 || a.keyword(i) == "" || isNameKeyword(a.keyword(i))):
+			parts = append(parts, tok.Text)
+		default:
+			return "", i, false
+		}
+
+		i++
+
+		if !a.isPunct(i, ".") {
+			return strings.Join(parts, "."), i, true
+		}
+
+		i++
+	}
+
+	return "", i, false
+}
+
+func (a *analyzer) addTable(name string) {
+	if _, exist := a.ctes[strings.ToLower(name)]; exist {
+		return
+	}
+
+	if _, exist := a.seen[name]; exist {
+		return
+	}
+
+	a.seen[name] = struct{}{}
+	a.result.Tables = append(a.result.Tables, name)
+}
+
+// unquote removes the quotes of a quoted identifier and unescapes doubled
+// quote characters.
+func unquote(ident string) string {
+	if len(ident) < 2 {
+		return ident
+	}
+
+	switch ident[0] {
+	case '"', '`':
+		quote := ident[:1]
+		return strings.ReplaceAll(strings.TrimSuffix(ident[1:], quote), quote+quote, quote)
+	case '[':
+		return strings.TrimSuffix(ident[1:], "]")
+	default:
+		return ident
+	}
+}
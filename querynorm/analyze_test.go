@@ -0,0 +1,179 @@
+package querynorm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name      string
+		dialect   Dialect
+		query     string
+		operation string
+		tables    []string
+	}{
+		{
+			name:      "select with joins and subquery",
+			query:     `SELECT u.id FROM users u JOIN accounts AS a ON a.uid = u.id, public."Orders" o WHERE x IN (SELECT y FROM z)`,
+			operation: "SELECT",
+			tables:    []string{"users", "accounts", "public.Orders", "z"},
+		},
+		{
+			name:      "cte",
+			query:     "WITH recent AS (SELECT * FROM orders), x(a) AS MATERIALIZED (SELECT 1) SELECT * FROM recent JOIN customers c USING (id)",
+			operation: "SELECT",
+			tables:    []string{"orders", "customers"},
+		},
+		{
+			name:      "cte with data-modifying main statement",
+			query:     "with ids as (select id from stale) delete from orders where id in (select id from ids)",
+			operation: "DELETE",
+			tables:    []string{"stale", "orders"},
+		},
+		{
+			name:      "insert on conflict returning",
+			query:     "INSERT INTO t (a, b) VALUES (1, 2) ON CONFLICT (a) DO UPDATE SET b = excluded.b RETURNING id",
+			operation: "INSERT",
+			tables:    []string{"t"},
+		},
+		{
+			name:      "update",
+			query:     "-- name: UpdateOrder\nupdate orders set a = 1 where id = 2",
+			operation: "UPDATE",
+			tables:    []string{"orders"},
+		},
+		{
+			name:      "delete using",
+			query:     "DELETE FROM a USING b WHERE a.id = b.id",
+			operation: "DELETE",
+			tables:    []string{"a", "b"},
+		},
+		{
+			name:      "table functions and locking clause",
+			query:     "SELECT * FROM generate_series(1, 10) g, LATERAL (SELECT 1 FROM q) s FOR UPDATE OF g",
+			operation: "SELECT",
+			tables:    []string{"q"},
+		},
+		{
+			name:      "parenthesized union",
+			query:     "/* c */ (SELECT 1 FROM a) UNION (SELECT 2 FROM b)",
+			operation: "SELECT",
+			tables:    []string{"a", "b"},
+		},
+		{
+			name:      "create table",
+			query:     "CREATE TABLE IF NOT EXISTS foo (a int)",
+			operation: "CREATE",
+			tables:    []string{"foo"},
+		},
+		{
+			name:      "mysql on duplicate key update",
+			dialect:   DialectMySQL,
+			query:     "INSERT INTO `db`.`t` (a) VALUES (1) ON DUPLICATE KEY UPDATE a = 2",
+			operation: "INSERT",
+			tables:    []string{"db.t"},
+		},
+		{
+			name:      "sqlite bracket identifiers",
+			dialect:   DialectSQLite,
+			query:     "SELECT * FROM [my table]",
+			operation: "SELECT",
+			tables:    []string{"my table"},
+		},
+		{
+			name:      "lists after from clause",
+			query:     "SELECT a, b FROM (SELECT a, b FROM x) s, y WHERE a IN (1, 2) ORDER BY a, b",
+			operation: "SELECT",
+			tables:    []string{"x", "y"},
+		},
+		{
+			name:      "from in function arguments",
+			query:     "SELECT EXTRACT(EPOCH FROM created_at), TRIM(BOTH ' ' FROM name), SUBSTRING(code FROM 2 FOR 3), POSITION('a' IN name) FROM orders o, customers WHERE EXTRACT(YEAR FROM o.created_at) = 2020",
+			operation: "SELECT",
+			tables:    []string{"orders", "customers"},
+		},
+		{
+			name:      "subquery in function arguments",
+			query:     "SELECT ARRAY(SELECT id FROM items), COALESCE((SELECT max(x) FROM y), 0) FROM orders",
+			operation: "SELECT",
+			tables:    []string{"items", "y", "orders"},
+		},
+		{
+			name:      "rename",
+			dialect:   DialectMySQL,
+			query:     "RENAME TABLE a TO b",
+			operation: "RENAME",
+			tables:    []string{"a"},
+		},
+		{
+			name:      "comment",
+			query:     "COMMENT ON TABLE orders IS 'x'",
+			operation: "COMMENT",
+			tables:    []string{"orders"},
+		},
+		{
+			name:      "keywords as table names",
+			query:     "SELECT * FROM comment c JOIN rename r ON r.id = c.id",
+			operation: "SELECT",
+			tables:    []string{"comment", "rename"},
+		},
+		{
+			name:      "key table",
+			dialect:   DialectMySQL,
+			query:     "INSERT INTO key (id) VALUES (1) ON DUPLICATE KEY UPDATE id = 1",
+			operation: "INSERT",
+			tables:    []string{"key"},
+		},
+		{
+			name:      "update keyword table",
+			query:     "UPDATE comment SET body = 'x'",
+			operation: "UPDATE",
+			tables:    []string{"comment"},
+		},
+		{
+			name:      "no tables",
+			query:     "SELECT 1",
+			operation: "SELECT",
+		},
+		{
+			name:  "empty",
+			query: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := Analyze(tt.query, tt.dialect)
+			assert.Equal(t, tt.operation, a.Operation)
+			assert.Equal(t, tt.tables, a.Tables)
+		})
+	}
+}
+
+func TestClosingParen(t *testing.T) {
+	tokens := Tokenize("SELECT COUNT((a)), MAX(b", DialectPostgres)
+
+	assert.Equal(t, 6, tokenClosingParen(tokens, 2))
+	assert.Equal(t, 5, tokenClosingParen(tokens, 3))
+	assert.Equal(t, -1, tokenClosingParen(tokens, 9))
+	assert.Equal(t, -1, tokenClosingParen(tokens, 0))
+	assert.Equal(t, -1, tokenClosingParen(tokens, len(tokens)))
+}
+
+func TestSelectsOnlyAggregates(t *testing.T) {
+	for query, expected := range map[string]bool{
+		"SELECT COUNT(*) FROM t":                  true,
+		"select count(*), max(id) AS m FROM t":    true,
+		"SELECT SUM((a + b)) total FROM t":        true,
+		"SELECT COUNT(*), id FROM t":              false,
+		"SELECT id FROM t":                        false,
+		"SELECT COUNT(* FROM t":                   false,
+		"UPDATE t SET a = 1":                      false,
+		"SELECT lower(name) FROM t":               false,
+		"SELECT COUNT(*) FROM t WHERE x IN (1,2)": true,
+	} {
+		assert.Equal(t, expected, SelectsOnlyAggregates(Tokenize(query, DialectPostgres)), query)
+	}
+}
@@ -0,0 +1,65 @@
+package querynorm
+
+// keywords are the SQL keywords that are uppercased during normalization.
+var keywords = map[string]struct{}{}
+
+// nameKeywords are keywords that are not reserved and are commonly used as
+// names of tables, e.g. COMMENT. They are only keywords at the start of a
+// statement or in clauses, not at positions where a name is expected.
+var nameKeywords = map[string]struct{}{}
+
+// functionKeywords are keywords that are followed by an argument list like
+// function calls.
+var functionKeywords = map[string]struct{}{}
+
+func init() {
+	for _, kw := range []string{
+		"ADD", "ALL", "ALTER", "ANALYZE", "AND", "ANY", "AS", "ASC",
+		"BEGIN", "BETWEEN", "BY", "CASCADE", "CASE", "CHECK", "COLLATE",
+		"COLUMN", "COMMENT", "COMMIT", "CONFLICT", "CONSTRAINT", "CREATE", "CROSS",
+		"DATABASE", "DEFAULT", "DELETE", "DESC", "DISTINCT", "DO", "DROP",
+		"DUPLICATE", "ELSE", "END", "ESCAPE", "EXCEPT", "EXISTS",
+		"EXPLAIN", "FALSE", "FETCH", "FILTER", "FIRST", "FOR", "FOREIGN",
+		"FROM", "FULL", "GRANT", "GROUP", "HAVING", "IF", "IGNORE",
+		"ILIKE", "IN", "INDEX", "INNER", "INSERT", "INTERSECT", "INTO",
+		"IS", "JOIN", "KEY", "LAST", "LATERAL", "LEFT", "LIKE", "LIMIT",
+		"LOCK", "MATERIALIZED", "MERGE", "NATURAL", "NOT", "NOTHING",
+		"NOWAIT", "NULL", "NULLS", "OF", "OFFSET", "ON", "ONLY", "OR",
+		"ORDER", "OUTER", "OVER", "PARTITION", "PRIMARY", "RECURSIVE",
+		"REFERENCES", "RENAME", "REPLACE", "RETURNING", "REVOKE", "RIGHT",
+		"ROLLBACK", "ROW", "ROWS", "SAVEPOINT", "SELECT", "SET", "SHARE",
+		"SHOW", "SKIP", "TABLE", "THEN", "TO", "TRUE", "TRUNCATE", "UNION",
+		"UNIQUE", "UPDATE", "USING", "VALUES", "VIEW", "WHEN", "WHERE",
+		"WINDOW", "WITH",
+	} {
+		keywords[kw] = struct{}{}
+	}
+
+	for _, kw := range []string{"COMMENT", "KEY", "RENAME"} {
+		nameKeywords[kw] = struct{}{}
+	}
+
+	for _, kw := range []string{
+		"AVG", "CAST", "COALESCE", "COUNT", "GREATEST", "LEAST", "LOWER",
+		"MAX", "MIN", "NOW", "NULLIF", "SUM", "UPPER",
+	} {
+		keywords[kw] = struct{}{}
+		functionKeywords[kw] = struct{}{}
+	}
+}
+
+// IsKeyword returns true if the uppercase word is a SQL keyword.
+func IsKeyword(upper string) bool {
+	_, exist := keywords[upper]
+	return exist
+}
+
+func isNameKeyword(upper string) bool {
+	_, exist := nameKeywords[upper]
+	return exist
+}
+
+func isFunctionKeyword(upper string) bool {
+	_, exist := functionKeywords[upper]
+	return exist
+}
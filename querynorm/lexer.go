@@ -0,0 +1,385 @@
+package querynorm
+
+import "strings"
+
+// Dialect defines the SQL dialect that is used to tokenize a query.
+type Dialect int
+
+// Supported SQL dialects.
+const (
+	// DialectPostgres supports dollar-quoted strings, E'' strings with
+	// backslash escapes, nested block comments and $n placeholders.
+	DialectPostgres Dialect = iota
+	// DialectMySQL supports double-quoted strings, backslash escapes,
+	// backtick-quoted identifiers and # comments.
+	DialectMySQL
+	// DialectSQLite supports backtick- and bracket-quoted identifiers
+	// and ?NNN, :name, @name and $name placeholders.
+	DialectSQLite
+)
+
+func (d Dialect) String() string {
+	switch d {
+	case DialectPostgres:
+		return "postgres"
+	case DialectMySQL:
+		return "mysql"
+	case DialectSQLite:
+		return "sqlite"
+	default:
+		return "unknown"
+	}
+}
+
+// TokenKind is the type of a Token.
+type TokenKind int
+
+// Token kinds returned by Tokenize.
+const (
+	// TokenWord is a keyword or an unquoted identifier.
+	TokenWord TokenKind = iota
+	// TokenQuotedIdentifier is a quoted identifier, the text contains
+	// the quotes.
+	TokenQuotedIdentifier
+	// TokenString is a string literal, the text contains the quotes and
+	// prefixes like E or X.
+	TokenString
+	// TokenNumber is a numeric literal.
+	TokenNumber
+	// TokenPlaceholder is a bind parameter placeholder like ?, $1 or
+	// :name.
+	TokenPlaceholder
+	// TokenOperator is an operator like =, <> or ::.
+	TokenOperator
+	// TokenPunctuation is one of ( ) [ ] , ; .
+	TokenPunctuation
+	// TokenComment is a line or block comment.
+	TokenComment
+)
+
+// Token is a lexical token of a SQL query.
+type Token struct {
+	Kind TokenKind
+	Text string
+}
+
+// operators are the multi-character operators that are recognized, ordered
+// by length in descending order.
+var operators = []string{
+	"<=>", "->>", "#>>", "!~*",
+	"<=", ">=", "<>", "!=", "==", "::", ":=", "||", "->", "#>", "@>", "<@",
+	"&&", "<<", ">>", "~*", "!~",
+}
+
+// Tokenize splits query into tokens.
+// It is tolerant, invalid or incomplete queries do not cause errors,
+// unterminated strings, identifiers and comments extend to the end of the
+// query.
+func Tokenize(query string, dialect Dialect) []Token {
+	l := lexer{query: query, dialect: dialect}
+	return l.run()
+}
+
+type lexer struct {
+	query   string
+	dialect Dialect
+	pos     int
+	tokens  []Token
+}
+
+func (l *lexer) run() []Token {
+	for l.pos < len(l.query) {
+		l.next()
+	}
+
+	return l.tokens
+}
+
+func (l *lexer) peek(offset int) byte {
+	if l.pos+offset >= len(l.query) {
+		return 0
+	}
+
+	return l.query[l.pos+offset]
+}
+
+func (l *lexer) emit(kind TokenKind, end int) {
+	l.tokens = append(l.tokens, Token{Kind: kind, Text: l.query[l.pos:end]})
+	l.pos = end
+}
+
+func (l *lexer) next() {
+	c := l.query[l.pos]
+
+	switch {
+	case isSpace(c):
+		l.pos++
+
+	case c == '-' && l.peek(1) == '-',
+		c == '#' && l.dialect == DialectMySQL:
+		l.emit(TokenComment, l.lineEnd())
+
+	case c == '/' && l.peek(1) == '*':
+		l.emit(TokenComment, l.blockCommentEnd())
+
+	case c == '\'':
+		l.emit(TokenString, l.quotedEnd(l.pos, '\'', l.dialect == DialectMySQL))
+
+	case c == '"':
+		if l.dialect == DialectMySQL {
+			l.emit(TokenString, l.quotedEnd(l.pos, '"', true))
+			return
+		}
+
+		l.emit(TokenQuotedIdentifier, l.quotedEnd(l.pos, '"', false))
+
+	case c == '`' && l.dialect != DialectPostgres:
+		l.emit(TokenQuotedIdentifier, l.quotedEnd(l.pos, '`', false))
+
+	case c == '[' && l.dialect == DialectSQLite:
+		end := strings.IndexByte(l.query[l.pos:], ']')
+		if end == -1 {
+			l.emit(TokenQuotedIdentifier, len(l.query))
+			return
+		}
+
+		l.emit(TokenQuotedIdentifier, l.pos+end+1)
+
+	case c == '$':
+		l.dollar()
+
+	case c == '?':
+		end := l.pos + 1
+		if l.dialect == DialectSQLite {
+			end = l.digitsEnd(end)
+		}
+
+		l.emit(TokenPlaceholder, end)
+
+	case (c == ':' && l.peek(1) != ':' && isIdentStart(l.peek(1)) && !l.prevIsColon()) ||
+		(c == '@' && l.dialect == DialectSQLite && isIdentStart(l.peek(1))):
+		l.emit(TokenPlaceholder, l.identEnd(l.pos+1))
+
+	case c == '@' && l.dialect == DialectMySQL && (isIdentStart(l.peek(1)) || l.peek(1) == '@'):
+		// user and system variables
+		end := l.pos + 1
+		if l.peek(1) == '@' {
+			end++
+		}
+
+		l.emit(TokenWord, l.identEnd(end))
+
+	case isDigit(c) || (c == '.' && isDigit(l.peek(1))):
+		l.emit(TokenNumber, l.numberEnd())
+
+	case isIdentStart(c):
+		l.word()
+
+	case strings.IndexByte("()[],;.", c) != -1:
+		l.emit(TokenPunctuation, l.pos+1)
+
+	default:
+		for _, op := range operators {
+			if strings.HasPrefix(l.query[l.pos:], op) {
+				l.emit(TokenOperator, l.pos+len(op))
+				return
+			}
+		}
+
+		l.emit(TokenOperator, l.pos+1)
+	}
+}
+
+func (l *lexer) prevIsColon() bool {
+	return l.pos > 0 && l.query[l.pos-1] == ':'
+}
+
+// word lexes an identifier or keyword. If it is a string prefix like E, X, B
+// or N that is directly followed by a quote, the string is lexed instead.
+func (l *lexer) word() {
+	end := l.identEnd(l.pos)
+
+	if end < len(l.query) && l.query[end] == '\'' {
+		switch strings.ToUpper(l.query[l.pos:end]) {
+		case "E":
+			l.emit(TokenString, l.quotedEnd(end, '\'', true))
+			return
+		case "B", "X", "N":
+			l.emit(TokenString, l.quotedEnd(end, '\'', l.dialect == DialectMySQL))
+			return
+		}
+
+		// MySQL character set introducers, e.g. _utf8mb4'abc'
+		if l.dialect == DialectMySQL && l.query[l.pos] == '_' {
+			l.emit(TokenString, l.quotedEnd(end, '\'', true))
+			return
+		}
+	}
+
+	l.emit(TokenWord, end)
+}
+
+// dollar lexes tokens starting with a '$' character.
+func (l *lexer) dollar() {
+	if isDigit(l.peek(1)) {
+		l.emit(TokenPlaceholder, l.digitsEnd(l.pos+1))
+		return
+	}
+
+	if l.dialect == DialectSQLite && isIdentStart(l.peek(1)) {
+		l.emit(TokenPlaceholder, l.identEnd(l.pos+1))
+		return
+	}
+
+	if l.dialect == DialectPostgres {
+		// dollar-quoted strings: $$...$$ or $tag$...$tag$
+		tagEnd := l.pos + 1
+		for tagEnd < len(l.query) && isIdentChar(l.query[tagEnd]) && l.query[tagEnd] != '$' {
+			tagEnd++
+		}
+
+		if tagEnd < len(l.query) && l.query[tagEnd] == '$' {
+			tag := l.query[l.pos : tagEnd+1]
+
+			end := strings.Index(l.query[tagEnd+1:], tag)
+			if end == -1 {
+				l.emit(TokenString, len(l.query))
+				return
+			}
+
+			l.emit(TokenString, tagEnd+1+end+len(tag))
+			return
+		}
+	}
+
+	l.emit(TokenOperator, l.pos+1)
+}
+
+func (l *lexer) lineEnd() int {
+	end := strings.IndexByte(l.query[l.pos:], '\n')
+	if end == -1 {
+		return len(l.query)
+	}
+
+	return l.pos + end
+}
+
+// blockCommentEnd returns the end of the block comment at the current
+// position, Postgres supports nested block comments.
+func (l *lexer) blockCommentEnd() int {
+	depth := 0
+
+	for i := l.pos; i < len(l.query)-1; i++ {
+		switch {
+		case l.query[i] == '/' && l.query[i+1] == '*':
+			depth++
+			i++
+
+		case l.query[i] == '*' && l.query[i+1] == '/':
+			depth--
+			i++
+
+			if depth == 0 || l.dialect != DialectPostgres {
+				return i + 1
+			}
+		}
+	}
+
+	return len(l.query)
+}
+
+// quotedEnd returns the position after the closing quote of the string or
+// identifier that starts at start.
+// A doubled quote character is an escaped quote. If backslashEscapes is
+// true, a backslash escapes the following character.
+func (l *lexer) quotedEnd(start int, quote byte, backslashEscapes bool) int {
+	for i := start + 1; i < len(l.query); i++ {
+		switch l.query[i] {
+		case '\\':
+			if backslashEscapes {
+				i++
+			}
+
+		case quote:
+			if i+1 < len(l.query) && l.query[i+1] == quote {
+				i++
+				continue
+			}
+
+			return i + 1
+		}
+	}
+
+	return len(l.query)
+}
+
+func (l *lexer) numberEnd() int {
+	i := l.pos
+
+	if l.query[i] == '0' && i+1 < len(l.query) && (l.query[i+1] == 'x' || l.query[i+1] == 'X') {
+		i += 2
+		for i < len(l.query) && isHexDigit(l.query[i]) {
+			i++
+		}
+
+		return i
+	}
+
+	i = l.digitsEnd(i)
+	if i < len(l.query) && l.query[i] == '.' {
+		i = l.digitsEnd(i + 1)
+	}
+
+	if i < len(l.query) && (l.query[i] == 'e' || l.query[i] == 'E') {
+		j := i + 1
+		if j < len(l.query) && (l.query[j] == '+' || l.query[j] == '-') {
+			j++
+		}
+
+		if j < len(l.query) && isDigit(l.query[j]) {
+			i = l.digitsEnd(j)
+		}
+	}
+
+	return i
+}
+
+func (l *lexer) digitsEnd(start int) int {
+	i := start
+	for i < len(l.query) && isDigit(l.query[i]) {
+		i++
+	}
+
+	return i
+}
+
+func (l *lexer) identEnd(start int) int {
+	i := start
+	for i < len(l.query) && isIdentChar(l.query[i]) {
+		i++
+	}
+
+	return i
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// isIdentStart returns true if c can start an identifier, bytes of
+// multi-byte UTF-8 characters are accepted as identifier characters.
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '$'
+}
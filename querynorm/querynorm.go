@@ -0,0 +1,237 @@
+// Package querynorm normalizes SQL queries to identify queries that only
+// differ in their literals, placeholders, formatting or keyword case.
+//
+// Normalization replaces literals and placeholders with '?', collapses
+// lists in IN (...) to a single element and multi-row VALUES lists to
+// their first row, removes comments, uppercases keywords and normalizes
+// whitespace.
+// The fingerprint of a query is the 64-bit FNV-1a hash of its normalized
+// form.
+//
+// Analyze determines the operation of a query and the tables it references,
+// Split splits queries with multiple statements.
+package querynorm
+
+import (
+	"hash/fnv"
+	"strings"
+)
+
+// Normalize returns the normalized form of query and its fingerprint.
+func Normalize(query string, dialect Dialect) (string, uint64) {
+	return NormalizeTokens(Tokenize(query, dialect))
+}
+
+// NormalizeTokens is like Normalize but operates on the tokens returned by
+// Tokenize.
+func NormalizeTokens(tokens []Token) (string, uint64) {
+	normalized := normalizeTokens(tokens)
+
+	return normalized, Fingerprint(normalized)
+}
+
+// Fingerprint returns the fingerprint of a normalized query.
+func Fingerprint(normalized string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(normalized))
+
+	return h.Sum64()
+}
+
+func normalizeTokens(tokens []Token) string {
+	words := make([]string, 0, len(tokens))
+
+	for _, tok := range tokens {
+		switch tok.Kind {
+		case TokenComment:
+			continue
+
+		case TokenString, TokenNumber, TokenPlaceholder:
+			words = append(words, "?")
+
+		case TokenWord:
+			if upper := strings.ToUpper(tok.Text); IsKeyword(upper) {
+				words = append(words, upper)
+				continue
+			}
+
+			words = append(words, tok.Text)
+
+		default:
+			words = append(words, tok.Text)
+		}
+	}
+
+	words = collapseLists(words)
+
+	for len(words) > 0 && words[len(words)-1] == ";" {
+		words = words[:len(words)-1]
+	}
+
+	return render(words)
+}
+
+// collapseLists replaces value lists in "IN (...)" with a single
+// placeholder and removes all but the first row of "VALUES (...), (...)".
+func collapseLists(words []string) []string {
+	result := make([]string, 0, len(words))
+	// skips maps the index of the closing parenthesis of the first row of
+	// a VALUES list to the index of the closing parenthesis of its last
+	// row
+	var skips map[int]int
+
+	for i := 0; i < len(words); i++ {
+		result = append(result, words[i])
+
+		if lastRowEnd, exist := skips[i]; exist {
+			i = lastRowEnd
+			continue
+		}
+
+		switch words[i] {
+		case "IN":
+			end := closingParen(words, i+1)
+			if end == -1 || !isValueList(words[i+2:end]) {
+				continue
+			}
+
+			result = append(result, "(", "?", ")")
+			i = end
+
+		case "VALUES":
+			end := closingParen(words, i+1)
+			if end == -1 {
+				continue
+			}
+
+			// the first row is processed by the loop, following
+			// rows are skipped when its end is reached
+			lastRowEnd := end
+			for next := end + 1; next < len(words) && words[next] == ","; {
+				rowEnd := closingParen(words, next+1)
+				if rowEnd == -1 {
+					break
+				}
+
+				lastRowEnd = rowEnd
+				next = rowEnd + 1
+			}
+
+			if lastRowEnd != end {
+				if skips == nil {
+					skips = map[int]int{}
+				}
+
+				skips[end] = lastRowEnd
+			}
+		}
+	}
+
+	return result
+}
+
+// closingParen returns the index of the parenthesis that closes the one at
+// words[start]. If words[start] is not an opening parenthesis or it is not
+// closed, -1 is returned.
+func closingParen(words []string, start int) int {
+	return matchParen(len(words), func(i int) string { return words[i] }, start)
+}
+
+// matchParen returns the index of the parenthesis that closes the one at
+// index start in a sequence of n elements, text returns the text of the
+// element at an index. If the element at start is not an opening
+// parenthesis or it is not closed, -1 is returned.
+func matchParen(n int, text func(int) string, start int) int {
+	if start < 0 || start >= n || text(start) != "(" {
+		return -1
+	}
+
+	depth := 0
+	for i := start; i < n; i++ {
+		switch text(i) {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// isValueList returns true if words only consists of comma separated
+// placeholders.
+func isValueList(words []string) bool {
+	if len(words) == 0 {
+		return false
+	}
+
+	for i, w := range words {
+		if i%2 == 0 && w != "?" {
+			return false
+		}
+
+		if i%2 == 1 && w != "," {
+			return false
+		}
+	}
+
+	return true
+}
+
+// render joins words with single spaces, no spaces are inserted inside
+// parentheses and brackets, before commas and around dots and casts.
+func render(words []string) string {
+	var sb strings.Builder
+
+	for i, w := range words {
+		if i > 0 && needsSpace(words, i) {
+			sb.WriteByte(' ')
+		}
+
+		sb.WriteString(w)
+	}
+
+	return sb.String()
+}
+
+func needsSpace(words []string, i int) bool {
+	prev, cur := words[i-1], words[i]
+
+	switch prev {
+	case "(", "[", ".", "::":
+		return false
+	}
+
+	switch cur {
+	case ")", "]", ",", ";", ".", "::", "[":
+		return false
+	case "(":
+		// function calls are rendered without a space, column lists
+		// after "INTO t" or "TABLE t" with one
+		if IsKeyword(prev) && !isFunctionKeyword(prev) {
+			return true
+		}
+
+		if i >= 2 && (words[i-2] == "INTO" || words[i-2] == "TABLE") {
+			return true
+		}
+
+		return prev == "?" || prev == "," || isOperator(prev)
+	}
+
+	return true
+}
+
+func isOperator(w string) bool {
+	if w == "" {
+		return false
+	}
+
+	c := w[0]
+
+	return !isIdentStart(c) && !isDigit(c) && c != '"' && c != '`' && c != '[' && c != ')' && c != ']'
+}
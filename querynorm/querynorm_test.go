@@ -0,0 +1,133 @@
+package querynorm
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		query   string
+		want    string
+	}{
+		{
+			name:  "literals and keyword case",
+			query: "select * from users where id = 12 and name = 'O''Brien' and x > -1.5e3",
+			want:  "SELECT * FROM users WHERE id = ? AND name = ? AND x > - ?",
+		},
+		{
+			name:  "whitespace and comments",
+			query: "  SELECT a\n\t FROM t  -- comment\n WHERE /* inline */ b = $1 ; ",
+			want:  "SELECT a FROM t WHERE b = ?",
+		},
+		{
+			name:  "in list",
+			query: "SELECT a FROM t WHERE b IN ($1, $2, $3) AND c NOT IN (1,2)",
+			want:  "SELECT a FROM t WHERE b IN (?) AND c NOT IN (?)",
+		},
+		{
+			name:  "in subquery",
+			query: "select a from t where b in (select c from d where e = 1)",
+			want:  "SELECT a FROM t WHERE b IN (SELECT c FROM d WHERE e = ?)",
+		},
+		{
+			name:  "multi-row values",
+			query: "INSERT INTO t(a, b) VALUES (1, 'x'), (2, now()),(3, 'z') ON CONFLICT (a) DO UPDATE SET b = excluded.b RETURNING id",
+			want:  "INSERT INTO t (a, b) VALUES (?, ?) ON CONFLICT (a) DO UPDATE SET b = excluded.b RETURNING id",
+		},
+		{
+			name:  "function calls and casts",
+			query: "SELECT count( * ), coalesce(a, 0) FROM t WHERE b :: text = E'x\\'y'",
+			want:  "SELECT COUNT(*), COALESCE(a, ?) FROM t WHERE b::text = ?",
+		},
+		{
+			name:  "postgres dollar quoting and quoted identifiers",
+			query: `SELECT $$a'b$$, $fn$ x $fn$ FROM "My Table" WHERE "a""b" = $1`,
+			want:  `SELECT ?, ? FROM "My Table" WHERE "a""b" = ?`,
+		},
+		{
+			name:  "postgres nested comments",
+			query: "SELECT /* a /* b */ c */ 1",
+			want:  "SELECT ?",
+		},
+		{
+			name:    "mysql",
+			dialect: DialectMySQL,
+			query:   "SELECT `a` FROM `db`.`t` WHERE b = \"x\\\"y\" AND c = _utf8mb4'z' AND d = 0xFF # comment",
+			want:    "SELECT `a` FROM `db`.`t` WHERE b = ? AND c = ? AND d = ?",
+		},
+		{
+			name:    "sqlite placeholders",
+			dialect: DialectSQLite,
+			query:   "SELECT [a] FROM t WHERE b = ?1 AND c = :c AND d = @d AND e = $e",
+			want:    "SELECT [a] FROM t WHERE b = ? AND c = ? AND d = ? AND e = ?",
+		},
+		{
+			name:  "unterminated string",
+			query: "SELECT 'abc",
+			want:  "SELECT ?",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, fp := Normalize(tt.query, tt.dialect)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, Fingerprint(tt.want), fp)
+		})
+	}
+}
+
+func TestFingerprintIsStable(t *testing.T) {
+	_, fp1 := Normalize("SELECT * FROM t WHERE a IN (1, 2) AND b = 'x'", DialectPostgres)
+	_, fp2 := Normalize("select *\nfrom t\nwhere a in ($1) and b = $2", DialectPostgres)
+	_, fp3 := Normalize("SELECT * FROM t WHERE a IN (1, 2) AND c = 'x'", DialectPostgres)
+
+	assert.Equal(t, fp1, fp2)
+	assert.NotEqual(t, fp1, fp3)
+}
+
+func TestTokenize(t *testing.T) {
+	tokens := Tokenize(`SELECT "a" FROM t WHERE b::int >= $1 -- c`, DialectPostgres)
+
+	assert.Equal(t, []Token{
+		{Kind: TokenWord, Text: "SELECT"},
+		{Kind: TokenQuotedIdentifier, Text: `"a"`},
+		{Kind: TokenWord, Text: "FROM"},
+		{Kind: TokenWord, Text: "t"},
+		{Kind: TokenWord, Text: "WHERE"},
+		{Kind: TokenWord, Text: "b"},
+		{Kind: TokenOperator, Text: "::"},
+		{Kind: TokenWord, Text: "int"},
+		{Kind: TokenOperator, Text: ">="},
+		{Kind: TokenPlaceholder, Text: "$1"},
+		{Kind: TokenComment, Text: "-- c"},
+	}, tokens)
+}
+
+func TestNormalizeLargeInsertIsLinear(t *testing.T) {
+	const rows = 50000
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO t (a, b) VALUES ")
+	for i := 0; i < rows; i++ {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+
+		sb.WriteString("(1, 'x')")
+	}
+
+	start := time.Now()
+	normalized, _ := Normalize(sb.String(), DialectPostgres)
+	elapsed := time.Since(start)
+
+	assert.Equal(t, "INSERT INTO t (a, b) VALUES (?, ?)", normalized)
+	// collapsing the rows in quadratic time takes several seconds
+	assert.Less(t, int64(elapsed), int64(2*time.Second), "normalizing took %s", elapsed)
+}
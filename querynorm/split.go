@@ -0,0 +1,48 @@
+package querynorm
+
+import "strings"
+
+// Split splits a query that contains multiple statements separated by
+// semicolons into the individual statements.
+// Semicolons in string literals, quoted identifiers and comments are
+// ignored. Statements are trimmed, empty statements and statements that
+// only consist of comments are omitted.
+func Split(query string, dialect Dialect) []string {
+	l := lexer{query: query, dialect: dialect}
+
+	var result []string
+	start := 0
+	hasContent := false
+
+	addStatement := func(end int) {
+		if hasContent {
+			result = append(result, strings.TrimSpace(query[start:end]))
+		}
+	}
+
+	for l.pos < len(query) {
+		pos := l.pos
+		cnt := len(l.tokens)
+
+		l.next()
+
+		if len(l.tokens) == cnt {
+			continue
+		}
+
+		tok := l.tokens[len(l.tokens)-1]
+		switch {
+		case tok.Kind == TokenPunctuation && tok.Text == ";":
+			addStatement(pos)
+			start = l.pos
+			hasContent = false
+
+		case tok.Kind != TokenComment:
+			hasContent = true
+		}
+	}
+
+	addStatement(len(query))
+
+	return result
+}
@@ -0,0 +1,60 @@
+package querynorm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		query   string
+		want    []string
+	}{
+		{
+			name:  "single statement",
+			query: "SELECT 1",
+			want:  []string{"SELECT 1"},
+		},
+		{
+			name:  "trailing semicolon and comment",
+			query: "SELECT 1; -- done\n",
+			want:  []string{"SELECT 1"},
+		},
+		{
+			name:  "quotes and comments",
+			query: `INSERT INTO t VALUES ('a;b'); /* ; */ UPDATE "x;y" SET a = 1 -- ;` + "\n;;DELETE FROM t",
+			want: []string{
+				"INSERT INTO t VALUES ('a;b')",
+				`/* ; */ UPDATE "x;y" SET a = 1 -- ;`,
+				"DELETE FROM t",
+			},
+		},
+		{
+			name:  "postgres dollar quoting",
+			query: "CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql; SELECT f()",
+			want: []string{
+				"CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql",
+				"SELECT f()",
+			},
+		},
+		{
+			name:    "mysql comments",
+			dialect: DialectMySQL,
+			query:   "SELECT 1 # ;\n; SELECT \"a;\"",
+			want:    []string{"SELECT 1 # ;", `SELECT "a;"`},
+		},
+		{
+			name:  "empty",
+			query: " ; ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Split(tt.query, tt.dialect))
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package sqltracing
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxQueryStats is the default maximum number of distinct queries
+// that are tracked by QueryStats.
+const DefaultMaxQueryStats = 1000
+
+// DefaultQueryStatsGracePeriod is the default duration after their first
+// execution, in which queries are protected from eviction.
+const DefaultQueryStatsGracePeriod = time.Minute
+
+// QueryStats aggregates execution statistics per query, similar to the
+// pg_stat_statements extension of PostgreSQL.
+// Queries are grouped by their fingerprints, as calculated by
+// querynorm.Normalize.
+//
+// The number of tracked queries is bounded. When the limit is reached, the
+// least often executed queries are evicted. Queries that were first
+// executed less than the grace period ago are only evicted when all
+// tracked queries are in their grace period, otherwise new queries would
+// replace each other before they could be counted.
+//
+// QueryStats implements http.Handler, it renders the statistics as HTML
+// table or, if the "format=json" query parameter is passed, as JSON array.
+// The "sort" query parameter defines the column the table is sorted by, it
+// is one of the JSON field names of QueryStat.
+type QueryStats struct {
+	maxQueries  int
+	gracePeriod time.Duration
+
+	mu      sync.Mutex
+	entries map[uint64]*queryStatsEntry
+}
+
+// QueryStat contains the statistics of a query.
+type QueryStat struct {
+	Fingerprint uint64 `json:"fingerprint"`
+	Query       string `json:"query"`
+	Calls       uint64 `json:"calls"`
+	Errors      uint64 `json:"errors"`
+	// Rows is the number of rows returned by queries or affected by
+	// exec operations.
+	Rows      int64         `json:"rows"`
+	TotalTime time.Duration `json:"total_time"`
+	MinTime   time.Duration `json:"min_time"`
+	MaxTime   time.Duration `json:"max_time"`
+	MeanTime  time.Duration `json:"mean_time"`
+	P50Time   time.Duration `json:"p50_time"`
+	P95Time   time.Duration `json:"p95_time"`
+	P99Time   time.Duration `json:"p99_time"`
+}
+
+type queryStatsEntry struct {
+	stat   QueryStat
+	sketch *quantileSketch
+	// created is the time when the entry was created.
+	created time.Time
+}
+
+// QueryStatsOpt is a type for options for QueryStats.
+type QueryStatsOpt func(*QueryStats)
+
+// WithMaxQueries sets the maximum number of distinct queries that are
+// tracked. The default is DefaultMaxQueryStats.
+// Values smaller than 1 are treated as 1.
+func WithMaxQueries(n int) QueryStatsOpt {
+	return func(s *QueryStats) {
+		if n < 1 {
+			n = 1
+		}
+
+		s.maxQueries = n
+	}
+}
+
+// WithEvictionGracePeriod sets the duration after their first execution,
+// in which queries are protected from eviction. The default is
+// DefaultQueryStatsGracePeriod.
+func WithEvictionGracePeriod(d time.Duration) QueryStatsOpt {
+	return func(s *QueryStats) {
+		s.gracePeriod = d
+	}
+}
+
+// NewQueryStats returns a new QueryStats.
+// It is passed via WithQueryStats to an Interceptor.
+func NewQueryStats(opts ...QueryStatsOpt) *QueryStats {
+	s := QueryStats{
+		maxQueries:  DefaultMaxQueryStats,
+		gracePeriod: DefaultQueryStatsGracePeriod,
+		entries:     map[uint64]*queryStatsEntry{},
+	}
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	return &s
+}
+
+type queryStatsOpCtxKey struct{}
+
+// queryStatsOp is stored in the context of an operation that is recorded in
+// QueryStats, it counts the rows that are read or affected by it.
+// database/sql does not use rows or results concurrently, therefore no
+// synchronization is needed.
+type queryStatsOp struct {
+	rows int64
+}
+
+// addQueryStatsRows adds n to the row counter of the operation in ctx.
+// If the operation is not recorded in QueryStats, the call is ignored.
+func addQueryStatsRows(ctx context.Context, n int64) {
+	if op, ok := ctx.Value(queryStatsOpCtxKey{}).(*queryStatsOp); ok {
+		op.rows += n
+	}
+}
+
+// observeFunc returns a function that records the execution of query when
+// it is called.
+// The returned context must be passed to addQueryStatsRows to count the rows
+// of the operation.
+func (s *QueryStats) observeFunc(ctx context.Context, query *parsedQuery) (func(err error), context.Context) {
+	op := queryStatsOp{}
+	startTime := time.Now()
+
+	return func(err error) {
+		s.record(query.fingerprint, query.text, time.Since(startTime), op.rows, err != nil)
+	}, context.WithValue(ctx, queryStatsOpCtxKey{}, &op)
+}
+
+func (s *QueryStats) record(key uint64, query string, d time.Duration, rows int64, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.entry(key, query)
+
+	entry.stat.Calls++
+	entry.stat.Rows += rows
+	if failed {
+		entry.stat.Errors++
+	}
+
+	entry.stat.TotalTime += d
+	if entry.stat.Calls == 1 || d < entry.stat.MinTime {
+		entry.stat.MinTime = d
+	}
+
+	if d > entry.stat.MaxTime {
+		entry.stat.MaxTime = d
+	}
+
+	entry.sketch.add(float64(d))
+}
+
+// entry returns the entry for key, if it does not exist it is created.
+// s.mu must be held when calling the method.
+func (s *QueryStats) entry(key uint64, query string) *queryStatsEntry {
+	if entry, exist := s.entries[key]; exist {
+		return entry
+	}
+
+	now := time.Now()
+
+	if len(s.entries) >= s.maxQueries {
+		s.evict(now)
+	}
+
+	entry := queryStatsEntry{
+		stat: QueryStat{
+			Fingerprint: key,
+			Query:       query,
+		},
+		sketch:  newQuantileSketch(),
+		created: now,
+	}
+	s.entries[key] = &entry
+
+	return &entry
+}
+
+// evict removes the 5% least often executed entries, at least 1.
+// Entries in their grace period are only removed when all entries are in
+// it, then the oldest ones are removed first on ties.
+// s.mu must be held when calling the method.
+func (s *QueryStats) evict(now time.Time) {
+	entries := make([]*queryStatsEntry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		if now.Sub(entry.created) >= s.gracePeriod {
+			entries = append(entries, entry)
+		}
+	}
+
+	if len(entries) == 0 {
+		for _, entry := range s.entries {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].stat.Calls != entries[j].stat.Calls {
+			return entries[i].stat.Calls < entries[j].stat.Calls
+		}
+
+		return entries[i].created.Before(entries[j].created)
+	})
+
+	cnt := len(s.entries) / 20
+	if cnt == 0 {
+		cnt = 1
+	}
+
+	if cnt > len(entries) {
+		cnt = len(entries)
+	}
+
+	for _, entry := range entries[:cnt] {
+		delete(s.entries, entry.stat.Fingerprint)
+	}
+}
+
+// Snapshot returns the statistics of all tracked queries, sorted by their
+// total execution time in descending order.
+func (s *QueryStats) Snapshot() []*QueryStat {
+	s.mu.Lock()
+	result := make([]*QueryStat, 0, len(s.entries))
+	for _, entry := range s.entries {
+		stat := entry.stat
+		stat.MeanTime = stat.TotalTime / time.Duration(stat.Calls)
+		stat.P50Time = time.Duration(entry.sketch.quantile(0.5))
+		stat.P95Time = time.Duration(entry.sketch.quantile(0.95))
+		stat.P99Time = time.Duration(entry.sketch.quantile(0.99))
+
+		result = append(result, &stat)
+	}
+	s.mu.Unlock()
+
+	sortQueryStats(result, "total_time")
+
+	return result
+}
+
+// Reset removes the statistics of all queries.
+func (s *QueryStats) Reset() {
+	s.mu.Lock()
+	s.entries = map[uint64]*queryStatsEntry{}
+	s.mu.Unlock()
+}
+
+// sortQueryStats sorts stats by the column with the passed JSON field name in
+// descending order. The query column is sorted ascending.
+func sortQueryStats(stats []*QueryStat, column string) {
+	var less func(a, b *QueryStat) bool
+
+	switch column {
+	case "query":
+		less = func(a, b *QueryStat) bool { return a.Query < b.Query }
+	case "calls":
+		less = func(a, b *QueryStat) bool { return a.Calls > b.Calls }
+	case "errors":
+		less = func(a, b *QueryStat) bool { return a.Errors > b.Errors }
+	case "rows":
+		less = func(a, b *QueryStat) bool { return a.Rows > b.Rows }
+	case "min_time":
+		less = func(a, b *QueryStat) bool { return a.MinTime > b.MinTime }
+	case "max_time":
+		less = func(a, b *QueryStat) bool { return a.MaxTime > b.MaxTime }
+	case "mean_time":
+		less = func(a, b *QueryStat) bool { return a.MeanTime > b.MeanTime }
+	case "p50_time":
+		less = func(a, b *QueryStat) bool { return a.P50Time > b.P50Time }
+	case "p95_time":
+		less = func(a, b *QueryStat) bool { return a.P95Time > b.P95Time }
+	case "p99_time":
+		less = func(a, b *QueryStat) bool { return a.P99Time > b.P99Time }
+	default:
+		less = func(a, b *QueryStat) bool { return a.TotalTime > b.TotalTime }
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		return less(stats[i], stats[j])
+	})
+}
+
+var queryStatsTemplate = template.Must(template.New("querystats").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>Query statistics</title>
+<style>
+table { border-collapse: collapse; font-family: monospace; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; text-align: left; vertical-align: top; }
+</style>
+</head>
+<body>
+<h1>Query statistics</h1>
+<p>{{len .}} queries</p>
+<table>
+<tr>
+<th><a href="?sort=query">Query</a></th>
+<th><a href="?sort=calls">Calls</a></th>
+<th><a href="?sort=errors">Errors</a></th>
+<th><a href="?sort=rows">Rows</a></th>
+<th><a href="?sort=total_time">Total</a></th>
+<th><a href="?sort=mean_time">Mean</a></th>
+<th><a href="?sort=min_time">Min</a></th>
+<th><a href="?sort=max_time">Max</a></th>
+<th><a href="?sort=p50_time">P50</a></th>
+<th><a href="?sort=p95_time">P95</a></th>
+<th><a href="?sort=p99_time">P99</a></th>
+</tr>
+{{range .}}<tr><td>{{.Query}}</td><td>{{.Calls}}</td><td>{{.Errors}}</td><td>{{.Rows}}</td><td>{{.TotalTime}}</td><td>{{.MeanTime}}</td><td>{{.MinTime}}</td><td>{{.MaxTime}}</td><td>{{.P50Time}}</td><td>{{.P95Time}}</td><td>{{.P99Time}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// ServeHTTP renders the query statistics.
+func (s *QueryStats) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	stats := s.Snapshot()
+	sortQueryStats(stats, req.URL.Query().Get("sort"))
+
+	if req.URL.Query().Get("format") == "json" || strings.Contains(req.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(stats)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := queryStatsTemplate.Execute(w, stats); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
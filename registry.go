@@ -0,0 +1,188 @@
+package sqltracing
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Registry keeps track of the database operations that are currently
+// running.
+// Operations that create transactions, statements or rows are active until
+// the created object is closed, committed or rolled back.
+// Operations that are excluded via WithOpsExcluded are not registered.
+// Operations on rows are not registered, they are covered by the query
+// operation that created the rows.
+//
+// Registry implements http.Handler, it renders the active operations as HTML
+// table or, if the "format=json" query parameter is passed, as JSON array.
+type Registry struct {
+	// nextID is accessed atomically.
+	nextID uint64
+	shards [registryShards]registryShard
+}
+
+// registryShards is the number of shards of the Registry. The operations
+// are distributed over the shards by their ID, to reduce the lock
+// contention when many operations run concurrently.
+const registryShards = 32
+
+type registryShard struct {
+	mu  sync.Mutex
+	ops map[uint64]*activeOp
+}
+
+// ActiveOp describes a running database operation.
+type ActiveOp struct {
+	ID        uint64        `json:"id"`
+	Op        SQLOp         `json:"op"`
+	Query     string        `json:"query,omitempty"`
+	QueryName string        `json:"query_name,omitempty"`
+	StartTime time.Time     `json:"start_time"`
+	Duration  time.Duration `json:"duration"`
+	// ConnID identifies the connection the operation runs on, it is 0 if
+	// it is unknown.
+	ConnID  uint64 `json:"conn_id,omitempty"`
+	TraceID string `json:"trace_id,omitempty"`
+	SpanID  string `json:"span_id,omitempty"`
+}
+
+type activeOp struct {
+	ActiveOp
+	// connID is accessed atomically, it is set when the operation is
+	// passed to a connection.
+	connID uint64
+}
+
+type activeOpCtxKey struct{}
+
+// NewRegistry returns a new Registry.
+// It is passed via WithRegistry to an Interceptor.
+func NewRegistry() *Registry {
+	var r Registry
+
+	for i := range r.shards {
+		r.shards[i].ops = map[uint64]*activeOp{}
+	}
+
+	return &r
+}
+
+func (r *Registry) shard(id uint64) *registryShard {
+	return &r.shards[id%registryShards]
+}
+
+func (r *Registry) register(ctx context.Context, op SQLOp, query, queryName string, span Span) (*activeOp, context.Context) {
+	traceID, spanID := spanIDs(span)
+
+	entry := activeOp{
+		ActiveOp: ActiveOp{
+			Op:        op,
+			Query:     query,
+			QueryName: queryName,
+			StartTime: time.Now(),
+			ID:        atomic.AddUint64(&r.nextID, 1),
+			TraceID:   traceID,
+			SpanID:    spanID,
+		},
+	}
+
+	shard := r.shard(entry.ID)
+	shard.mu.Lock()
+	shard.ops[entry.ID] = &entry
+	shard.mu.Unlock()
+
+	return &entry, context.WithValue(ctx, activeOpCtxKey{}, &entry)
+}
+
+func (r *Registry) unregister(entry *activeOp) {
+	shard := r.shard(entry.ID)
+	shard.mu.Lock()
+	delete(shard.ops, entry.ID)
+	shard.mu.Unlock()
+}
+
+// setActiveOpConn records the connection on the active operation in ctx.
+func setActiveOpConn(ctx context.Context, conn *tracedConn) {
+	if conn == nil {
+		return
+	}
+
+	if entry, ok := ctx.Value(activeOpCtxKey{}).(*activeOp); ok {
+		atomic.StoreUint64(&entry.connID, conn.id)
+	}
+}
+
+// Active returns the currently running operations, sorted by their start
+// time.
+func (r *Registry) Active() []*ActiveOp {
+	now := time.Now()
+
+	result := []*ActiveOp{}
+
+	for i := range r.shards {
+		shard := &r.shards[i]
+
+		shard.mu.Lock()
+		for _, entry := range shard.ops {
+			op := entry.ActiveOp
+			op.ConnID = atomic.LoadUint64(&entry.connID)
+			op.Duration = now.Sub(op.StartTime)
+
+			result = append(result, &op)
+		}
+		shard.mu.Unlock()
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].StartTime.Equal(result[j].StartTime) {
+			return result[i].ID < result[j].ID
+		}
+
+		return result[i].StartTime.Before(result[j].StartTime)
+	})
+
+	return result
+}
+
+var registryTemplate = template.Must(template.New("registry").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>Active database operations</title>
+<style>
+table { border-collapse: collapse; font-family: monospace; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; text-align: left; vertical-align: top; }
+</style>
+</head>
+<body>
+<h1>Active database operations</h1>
+<p>{{len .}} operations</p>
+<table>
+<tr><th>ID</th><th>Operation</th><th>Duration</th><th>Started</th><th>Connection</th><th>Trace</th><th>Span</th><th>Name</th><th>Query</th></tr>
+{{range .}}<tr><td>{{.ID}}</td><td>{{.Op}}</td><td>{{.Duration}}</td><td>{{.StartTime.Format "2006-01-02T15:04:05.000Z07:00"}}</td><td>{{if .ConnID}}{{.ConnID}}{{end}}</td><td>{{.TraceID}}</td><td>{{.SpanID}}</td><td>{{.QueryName}}</td><td>{{.Query}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// ServeHTTP renders the currently running operations.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ops := r.Active()
+
+	if req.URL.Query().Get("format") == "json" || strings.Contains(req.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(ops)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := registryTemplate.Execute(w, ops); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
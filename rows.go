@@ -3,18 +3,158 @@ package sqltracing
 import (
 	"context"
 	"database/sql/driver"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Defines the names of the tracing tags that describe the columns of a
+// result set.
+const (
+	DBColumnsCountTagKey = "db.columns.count"
+	DBColumnsNamesTagKey = "db.columns.names"
+	DBColumnsTypesTagKey = "db.columns.types"
+)
+
+// Defines the names of the tracing tags that describe the timing of reading
+// a result set.
+// DBTTFRTagKey contains the duration from the start of the query until the
+// first row was read, DBFetchDurationTagKey the duration from the return of
+// the query until the last row was read. DBClientIdleTagKey contains the
+// duration between the return of the query and closing the rows, in which
+// no row was read.
+const (
+	DBTTFRTagKey          = "db.ttfr"
+	DBFetchDurationTagKey = "db.fetch_duration"
+	DBClientIdleTagKey    = "db.client_idle"
+)
+
+// tracedRows wraps the rows returned by ConnQueryContext and
+// StmtQueryContext.
+// sqlmw does not forward the optional driver.Rows interfaces, the ones
+// that are implemented by the wrapped rows are made available to
+// database/sql by outerRows.
 type tracedRows struct {
 	driver.Rows
 	ctx                context.Context
+	parentSpan         Span
 	parentSpanFinishFn func(err error)
+	columnsRecorded    bool
+
+	// started is the time when the query was started, returned when it
+	// returned the rows
+	started  time.Time
+	returned time.Time
+	// firstRow is the time when the first row was read, lastNext when the
+	// last Next call returned
+	firstRow time.Time
+	lastNext time.Time
+	// nextDuration is the sum of the durations of the Next calls
+	nextDuration time.Duration
 }
 
-func newTracedRows(ctx context.Context, parentSpanFinishFn func(error), rows driver.Rows) *tracedRows {
+func newTracedRows(ctx context.Context, parentSpan Span, parentSpanFinishFn func(error), rows driver.Rows, started time.Time) *tracedRows {
 	return &tracedRows{
 		Rows:               rows,
 		ctx:                ctx,
+		parentSpan:         parentSpan,
 		parentSpanFinishFn: parentSpanFinishFn,
+		started:            started,
+		returned:           time.Now(),
+	}
+}
+
+// observeNext records the timing of a Next call that started at start and
+// returned err.
+func (r *tracedRows) observeNext(start time.Time, err error) {
+	now := time.Now()
+
+	r.nextDuration += now.Sub(start)
+	r.lastNext = now
+
+	if err == nil && r.firstRow.IsZero() {
+		r.firstRow = now
+	}
+}
+
+// recordTimings sets the DBTTFRTagKey, DBFetchDurationTagKey and
+// DBClientIdleTagKey tags on the span of the operation that created the
+// rows. It is called when the rows are closed.
+func (r *tracedRows) recordTimings() {
+	tags := map[string]string{
+		DBClientIdleTagKey: (time.Since(r.returned) - r.nextDuration).String(),
+	}
+
+	if !r.firstRow.IsZero() {
+		tags[DBTTFRTagKey] = r.firstRow.Sub(r.started).String()
+	}
+
+	if !r.lastNext.IsZero() {
+		tags[DBFetchDurationTagKey] = r.lastNext.Sub(r.returned).String()
+	}
+
+	r.parentSpan.SetTags(tags)
+}
+
+// recordColumns sets tags describing the columns of the result set on the
+// span of the operation that created the rows.
+// The tags are only recorded on the first invocation.
+func (r *tracedRows) recordColumns(withNames bool) {
+	if r.columnsRecorded {
+		return
+	}
+
+	r.columnsRecorded = true
+
+	columns := r.Columns()
+	tags := map[string]string{
+		DBColumnsCountTagKey: strconv.Itoa(len(columns)),
+	}
+
+	if withNames {
+		tags[DBColumnsNamesTagKey] = strings.Join(columns, ",")
+
+		if typeNamer, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+			types := make([]string, len(columns))
+			for i := range columns {
+				types[i] = typeNamer.ColumnTypeDatabaseTypeName(i)
+			}
+
+			tags[DBColumnsTypesTagKey] = strings.Join(types, ",")
+		}
+	}
+
+	r.parentSpan.SetTags(tags)
+}
+
+type rowsRefCtxKey struct{}
+
+// rowsRef is passed via the context to ConnQueryContext and
+// StmtQueryContext, they store the returned tracedRows in it.
+// This allows outerConn and outerStmt to access the tracedRows that sqlmw
+// wraps.
+type rowsRef struct {
+	rows *tracedRows
+}
+
+func withRowsRef(ctx context.Context) (context.Context, *rowsRef) {
+	ref := rowsRef{}
+	return context.WithValue(ctx, rowsRefCtxKey{}, &ref), &ref
+}
+
+// rowsRefFromContext returns the rowsRef in ctx. If it does not exist, nil
+// is returned.
+func rowsRefFromContext(ctx context.Context) *rowsRef {
+	ref, _ := ctx.Value(rowsRefCtxKey{}).(*rowsRef)
+	return ref
+}
+
+// set stores rows in the rowsRef and returns them.
+// It is safe to be called on a nil rowsRef.
+func (r *rowsRef) set(rows *tracedRows) *tracedRows {
+	if r != nil {
+		r.rows = rows
 	}
+
+	return rows
 }
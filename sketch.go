@@ -0,0 +1,75 @@
+package sqltracing
+
+import (
+	"math"
+	"sort"
+)
+
+// quantileSketchAccuracy is the relative accuracy of the quantiles returned
+// by quantileSketch.
+const quantileSketchAccuracy = 0.01
+
+// quantileSketch is a streaming quantile estimator.
+// Values are counted in buckets with logarithmically growing sizes, as in
+// DDSketch. Quantiles are returned with a relative error of at most
+// quantileSketchAccuracy, the memory usage only depends on the range of
+// the values.
+// Values smaller than 1 are counted in a separate bucket.
+type quantileSketch struct {
+	gamma      float64
+	logGamma   float64
+	buckets    map[int]uint64
+	zeroCount  uint64
+	totalCount uint64
+}
+
+func newQuantileSketch() *quantileSketch {
+	gamma := (1 + quantileSketchAccuracy) / (1 - quantileSketchAccuracy)
+
+	return &quantileSketch{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		buckets:  map[int]uint64{},
+	}
+}
+
+func (s *quantileSketch) add(v float64) {
+	s.totalCount++
+
+	if v < 1 {
+		s.zeroCount++
+		return
+	}
+
+	s.buckets[int(math.Ceil(math.Log(v)/s.logGamma))]++
+}
+
+// quantile returns the estimated value at quantile q, q must be in the range
+// [0, 1].
+func (s *quantileSketch) quantile(q float64) float64 {
+	if s.totalCount == 0 {
+		return 0
+	}
+
+	rank := uint64(q * float64(s.totalCount-1))
+	if rank < s.zeroCount {
+		return 0
+	}
+
+	keys := make([]int, 0, len(s.buckets))
+	for k := range s.buckets {
+		keys = append(keys, k)
+	}
+
+	sort.Ints(keys)
+
+	cnt := s.zeroCount
+	for _, k := range keys {
+		cnt += s.buckets[k]
+		if cnt > rank {
+			return 2 * math.Pow(s.gamma, float64(k)) / (s.gamma + 1)
+		}
+	}
+
+	return 2 * math.Pow(s.gamma, float64(keys[len(keys)-1])) / (s.gamma + 1)
+}
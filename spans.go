@@ -3,24 +3,248 @@ package sqltracing
 import (
 	"context"
 	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 // DBStatementTagKey is the name of the tracing that contains db query
 // statements.
 const DBStatementTagKey = "db.statement"
 
-func (d *Interceptor) startSpan(ctx context.Context, opName SQLOp, query string, whitelistedErr ...error) (func(err error), context.Context) {
+// Defines the names of the tracing tags that are set when the statement in
+// the DBStatementTagKey tag was truncated.
+// DBStatementLengthTagKey contains the length of the full statement in bytes
+// and DBStatementHashTagKey its 64-bit FNV-1a hash as hex string.
+const (
+	DBStatementLengthTagKey = "db.statement.length"
+	DBStatementHashTagKey   = "db.statement.hash"
+)
+
+// StatementTruncationMarker is appended to statements that were truncated
+// because they exceeded the length configured via WithMaxStatementLength.
+const StatementTruncationMarker = "...[truncated]"
+
+// DBQueryFingerprintTagKey is the name of the tracing tag that contains the
+// fingerprint of the query, as hex string.
+// Queries that only differ in their literals, placeholders, formatting or
+// keyword case have the same fingerprint.
+const DBQueryFingerprintTagKey = "db.query.fingerprint"
+
+// DBOperationTagKey is the name of the tracing tag that contains the
+// operation of the query statement, e.g. SELECT or UPDATE.
+const DBOperationTagKey = "db.operation"
+
+// DBSQLTableTagKey is the name of the tracing tag that contains the comma
+// separated names of the tables that are referenced by the query statement.
+const DBSQLTableTagKey = "db.sql.table"
+
+// DBRowsAffectedTagKey is the name of the tracing tag that contains the
+// number of rows affected by an exec operation.
+const DBRowsAffectedTagKey = "db.rows_affected"
+
+// DBLastInsertIDTagKey is the name of the tracing tag that contains the
+// last insert id returned for an exec operation.
+const DBLastInsertIDTagKey = "db.last_insert_id"
+
+// Defines the names of the tracing tags that are recorded for transactions.
+const (
+	DBTxIsolationTagKey  = "db.tx.isolation"
+	DBTxReadOnlyTagKey   = "db.tx.read_only"
+	DBTxOutcomeTagKey    = "db.tx.outcome"
+	DBTxStatementsTagKey = "db.tx.statements"
+)
+
+func (d *Interceptor) startSpan(ctx context.Context, opName SQLOp, query string, whitelistedErr ...error) (Span, func(err error), context.Context) {
 	if d.opIsExcluded(opName) {
-		return func(_ error) {}, ctx
+		return noopSpan{}, func(_ error) {}, ctx
+	}
+
+	var queryName string
+	var queryNameFromCtx bool
+	if opName.hasQuery() {
+		queryName, queryNameFromCtx = d.queryName(ctx, query)
+	}
+
+	var parsed *parsedQuery
+	if query != "" && d.needsParsedQuery(opName) {
+		parsed = d.queryCache.get(query)
+	}
+
+	spanName := opName.String()
+	if queryName != "" && d.queryNameAsSpanName {
+		spanName = queryName
+	}
+
+	parentCtx := ctx
+	span, ctx := d.tracer.StartSpan(ctx, spanName)
+
+	// the spans of operations on rows, results, statements and
+	// transactions are tagged with the tags of their connection
+	if d.onConnect != nil {
+		connFromContext(parentCtx).tagSpan(span)
+	}
+
+	// the statement is only tagged on the prepare span, not on the spans
+	// of operations on the prepared statement
+	if query != "" && !opName.isStmtOp() {
+		d.tagStatement(span, query)
+	}
+
+	if parsed != nil {
+		d.tagParsedQuery(span, opName, parsed)
+
+		if d.nPlusOne != nil && opName.isQueryExecution() {
+			d.nPlusOne.check(nPlusOneParentCtx(parentCtx), d.tracer, span, parsed)
+		}
+	}
+
+	if queryName != "" {
+		span.SetTag(DBQueryNameTagKey, queryName)
+
+		// the name is stored in the context to make it available to
+		// operations on statements that were prepared with the query
+		if !queryNameFromCtx {
+			ctx = WithQueryName(ctx, queryName)
+		}
+	}
+
+	if d.poolStats != nil && opName.takesConn() {
+		if delta, ok := d.poolStats.WaitDurationDelta(); ok {
+			span.SetTag(DBPoolWaitDurationTagKey, delta.String())
+		}
+	}
+
+	if d.callSiteResolver != nil {
+		var site *callSite
+		if ctx, site = d.callSiteResolver.forOp(ctx, opName); site != nil {
+			span.SetTags(site.tags())
+		}
+	}
+
+	finishFn := spanFinishFunc(span, whitelistedErr...)
+
+	// operations on rows are covered by the entry of the query, that is
+	// active until the rows are closed
+	if d.registry != nil && !opName.isRowsOp() {
+		var entry *activeOp
+
+		entry, ctx = d.registry.register(ctx, opName, d.limitStatement(query), queryName, span)
+		spanFinishFn := finishFn
+		finishFn = func(err error) {
+			d.registry.unregister(entry)
+			spanFinishFn(err)
+		}
 	}
 
-	span, ctx := d.tracer.StartSpan(ctx, opName.String())
+	if parsed != nil && d.queryStats != nil && opName.isQueryExecution() {
+		var observeFn func(error)
 
-	if query != "" {
+		observeFn, ctx = d.queryStats.observeFunc(ctx, parsed)
+		spanFinishFn := finishFn
+		finishFn = func(err error) {
+			spanFinishFn(err)
+			observeFn(err)
+		}
+	}
+
+	if d.metrics == nil {
+		return span, finishFn, ctx
+	}
+
+	var fingerprint string
+	if parsed != nil && d.metricsFingerprint {
+		fingerprint = parsed.fingerprintString()
+	}
+
+	observeFn := d.observeFunc(ctx, opName, queryName, fingerprint, whitelistedErr)
+
+	return span, func(err error) {
+		finishFn(err)
+		observeFn(err)
+	}, ctx
+}
+
+// tagStatement sets the DBStatementTagKey tag to query.
+// If it is longer than the configured maximum length, it is truncated and
+// tagged with its length and hash.
+func (d *Interceptor) tagStatement(span Span, query string) {
+	if d.maxStatementLength <= 0 || len(query) <= d.maxStatementLength {
 		span.SetTag(DBStatementTagKey, query)
+		return
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(query))
+
+	span.SetTags(map[string]string{
+		DBStatementTagKey:       d.limitStatement(query),
+		DBStatementLengthTagKey: strconv.Itoa(len(query)),
+		DBStatementHashTagKey:   fmt.Sprintf("%016x", h.Sum64()),
+	})
+}
+
+// limitStatement returns query truncated to the configured maximum length.
+func (d *Interceptor) limitStatement(query string) string {
+	if d.maxStatementLength <= 0 || len(query) <= d.maxStatementLength {
+		return query
+	}
+
+	return truncateStatement(query, d.maxStatementLength)
+}
+
+// truncateStatement shortens query to maxLen bytes including the
+// StatementTruncationMarker. The query is only cut at UTF-8 character
+// boundaries.
+func truncateStatement(query string, maxLen int) string {
+	n := maxLen - len(StatementTruncationMarker)
+	if n < 0 {
+		n = 0
+	}
+
+	for n > 0 && !utf8.RuneStart(query[n]) {
+		n--
+	}
+
+	return query[:n] + StatementTruncationMarker
+}
+
+// needsParsedQuery returns true if the query of an operation must be parsed
+// for the enabled features.
+func (d *Interceptor) needsParsedQuery(op SQLOp) bool {
+	if d.tagFingerprint || d.tagOperationAndTables || (d.tagBatches && !op.isStmtOp()) {
+		return true
+	}
+
+	if d.metricsFingerprint && d.metrics != nil {
+		return true
+	}
+
+	return (d.queryStats != nil || d.nPlusOne != nil) && op.isQueryExecution()
+}
+
+func (d *Interceptor) tagParsedQuery(span Span, op SQLOp, parsed *parsedQuery) {
+	if d.tagFingerprint {
+		span.SetTag(DBQueryFingerprintTagKey, parsed.fingerprintString())
+	}
+
+	if d.tagBatches && parsed.batch != nil && !op.isStmtOp() {
+		tagBatch(span, parsed.batch)
+	}
+
+	if !d.tagOperationAndTables {
+		return
 	}
 
-	return spanFinishFunc(span, whitelistedErr...), ctx
+	if parsed.operation != "" {
+		span.SetTag(DBOperationTagKey, parsed.operation)
+	}
+
+	if len(parsed.tables) > 0 {
+		span.SetTag(DBSQLTableTagKey, strings.Join(parsed.tables, ","))
+	}
 }
 
 func spanFinishFunc(span Span, whitelistedErr ...error) func(err error) {
@@ -42,3 +266,11 @@ func errisOneOf(err error, targets []error) bool {
 
 	return false
 }
+
+// noopSpan is returned by startSpan for excluded operations.
+type noopSpan struct{}
+
+func (noopSpan) SetTag(_, _ string)          {}
+func (noopSpan) SetTags(_ map[string]string) {}
+func (noopSpan) SetError(_ error)            {}
+func (noopSpan) Finish()                     {}
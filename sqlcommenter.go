@@ -0,0 +1,206 @@
+package sqltracing
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// TraceParenter is an optional interface that can be implemented by Spans
+// to propagate their context in the W3C Trace Context format.
+type TraceParenter interface {
+	// TraceParent returns the value of the traceparent header for the
+	// span, e.g. "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+	// It returns an empty string if it is unknown.
+	TraceParent() string
+}
+
+// DefaultSQLCommenterOps are the operations whose queries are commented by
+// default.
+var DefaultSQLCommenterOps = []SQLOp{OpSQLConnExec, OpSQLConnQuery}
+
+// SQLCommenter appends comments in the sqlcommenter format
+// (https://google.github.io/sqlcommenter/) to queries, to correlate them in
+// database logs with traces.
+// The comment contains the traceparent of the span of the operation, the
+// application name and the route from the context.
+//
+// Queries that already contain a comment are not modified.
+// By default comments are only added to queries without arguments of the
+// DefaultSQLCommenterOps operations. Adding comments with changing values
+// to prepared statements and queries with arguments defeats the caches for
+// prepared statements of the database and of drivers like pgx.
+type SQLCommenter struct {
+	ops           map[SQLOp]struct{}
+	application   string
+	parameterized bool
+}
+
+// SQLCommenterOpt is a type for options for the SQLCommenter.
+type SQLCommenterOpt func(*SQLCommenter)
+
+// WithCommentedOps sets the operations whose queries are commented.
+// Supported are OpSQLConnExec, OpSQLConnQuery and OpSQLPrepare.
+// The default is DefaultSQLCommenterOps.
+func WithCommentedOps(ops ...SQLOp) SQLCommenterOpt {
+	return func(c *SQLCommenter) {
+		c.ops = map[SQLOp]struct{}{}
+		for _, op := range ops {
+			c.ops[op] = struct{}{}
+		}
+	}
+}
+
+// WithCommentApplication sets the application name that is added to the
+// comments.
+func WithCommentApplication(name string) SQLCommenterOpt {
+	return func(c *SQLCommenter) {
+		c.application = name
+	}
+}
+
+// WithCommentedArgs enables adding comments to queries that are run with
+// arguments.
+func WithCommentedArgs() SQLCommenterOpt {
+	return func(c *SQLCommenter) {
+		c.parameterized = true
+	}
+}
+
+// NewSQLCommenter returns a new SQLCommenter.
+// It is passed via WithSQLCommenter to an Interceptor.
+func NewSQLCommenter(opts ...SQLCommenterOpt) *SQLCommenter {
+	c := SQLCommenter{}
+	WithCommentedOps(DefaultSQLCommenterOps...)(&c)
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return &c
+}
+
+type routeCtxKey struct{}
+
+// WithRoute returns a new context that contains the route of the request
+// that is processed by the application, e.g. "/users/{id}".
+// The route is added to the comments of the SQLCommenter.
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeCtxKey{}, route)
+}
+
+func routeFromContext(ctx context.Context) string {
+	route, _ := ctx.Value(routeCtxKey{}).(string)
+	return route
+}
+
+// comment returns query with the sqlcommenter comment appended.
+// If the query must not be commented, it is returned unchanged.
+func (c *SQLCommenter) comment(ctx context.Context, op SQLOp, span Span, query string, hasArgs bool) string {
+	if _, exist := c.ops[op]; !exist {
+		return query
+	}
+
+	if hasArgs && !c.parameterized {
+		return query
+	}
+
+	if query == "" || strings.Contains(query, "/*") || strings.Contains(query, "--") {
+		return query
+	}
+
+	attrs := map[string]string{}
+
+	if traceParent := traceParent(span); traceParent != "" {
+		attrs["traceparent"] = traceParent
+	}
+
+	if c.application != "" {
+		attrs["application"] = c.application
+	}
+
+	if route := routeFromContext(ctx); route != "" {
+		attrs["route"] = route
+	}
+
+	if len(attrs) == 0 {
+		return query
+	}
+
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	var sb strings.Builder
+
+	trimmed := strings.TrimRight(query, " \t\r\n")
+	stmt := strings.TrimSuffix(trimmed, ";")
+
+	sb.WriteString(stmt)
+	sb.WriteString(" /*")
+
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+
+		sb.WriteString(url.PathEscape(k))
+		sb.WriteString("='")
+		sb.WriteString(url.PathEscape(attrs[k]))
+		sb.WriteByte('\'')
+	}
+
+	sb.WriteString("*/")
+
+	if len(stmt) != len(trimmed) {
+		sb.WriteByte(';')
+	}
+
+	return sb.String()
+}
+
+// traceParent returns the W3C traceparent of span.
+// If the span does not implement TraceParenter, it is built from the IDs
+// returned by SpanIDer, if they are hex encoded. The sampled flag is only
+// set if the span implements SpanSampler and is sampled.
+func traceParent(span Span) string {
+	if tp, ok := span.(TraceParenter); ok {
+		return tp.TraceParent()
+	}
+
+	traceID, spanID := spanIDs(span)
+	if !isHex(traceID, 32) || !isHex(spanID, 16) {
+		return ""
+	}
+
+	flags := "00"
+	if sampler, ok := span.(SpanSampler); ok && sampler.IsSampled() {
+		flags = "01"
+	}
+
+	return "00-" + zeroPad(traceID, 32) + "-" + zeroPad(spanID, 16) + "-" + flags
+}
+
+func zeroPad(s string, n int) string {
+	return strings.Repeat("0", n-len(s)) + s
+}
+
+// isHex returns true if s is a non-empty hex string with at most maxLen
+// characters.
+func isHex(s string, maxLen int) bool {
+	if s == "" || len(s) > maxLen {
+		return false
+	}
+
+	for _, c := range s {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+
+	return true
+}
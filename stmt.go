@@ -5,16 +5,109 @@ import (
 	"database/sql/driver"
 )
 
+// tracedStmt wraps the statements created by ConnPrepareContext.
+//
+// All optional driver interfaces that are forwarded by sqlmw are
+// implemented, the same fallbacks as in sqlmw are used when the wrapped
+// statement does not support them.
 type tracedStmt struct {
 	driver.Stmt
 	ctx                context.Context
 	parentSpanFinishFn func(err error)
+	conn               *tracedConn
+	query              string
 }
 
-func newTracedStmt(ctx context.Context, parentSpanFinishFn func(error), stmt driver.Stmt) *tracedStmt {
+// Compile time validation that our types implement the expected interfaces
+var (
+	_ driver.Stmt              = &tracedStmt{}
+	_ driver.StmtExecContext   = &tracedStmt{}
+	_ driver.StmtQueryContext  = &tracedStmt{}
+	_ driver.ColumnConverter   = &tracedStmt{}
+	_ driver.NamedValueChecker = &tracedStmt{}
+)
+
+func newTracedStmt(ctx context.Context, parentSpanFinishFn func(error), stmt driver.Stmt, conn *tracedConn, query string) *tracedStmt {
 	return &tracedStmt{
 		Stmt:               stmt,
 		ctx:                ctx,
 		parentSpanFinishFn: parentSpanFinishFn,
+		conn:               conn,
+		query:              query,
+	}
+}
+
+// stmtCtx returns the context for an operation on the statement.
+// It is derived from the context of the operation that created the
+// statement. A query name in callerCtx has precedence over the one in the
+// statement context. callerCtx is stored in the returned context, the
+// NPlusOneDetector uses its span as parent.
+func (s *tracedStmt) stmtCtx(callerCtx context.Context) context.Context {
+	ctx := withNPlusOneParentCtx(s.ctx, callerCtx)
+
+	if name := queryNameFromContext(callerCtx); name != "" {
+		return WithQueryName(ctx, name)
+	}
+
+	return ctx
+}
+
+func (s *tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return execer.ExecContext(ctx, args)
+	}
+
+	dargs, err := namedValueToValue(args)
+	if err != nil {
+		return nil, err
 	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+		return s.Stmt.Exec(dargs)
+	}
+}
+
+func (s *tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return queryer.QueryContext(ctx, args)
+	}
+
+	dargs, err := namedValueToValue(args)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+		return s.Stmt.Query(dargs)
+	}
+}
+
+func (s *tracedStmt) ColumnConverter(idx int) driver.ValueConverter {
+	if converter, ok := s.Stmt.(driver.ColumnConverter); ok {
+		return converter.ColumnConverter(idx)
+	}
+
+	return driver.DefaultParameterConverter
+}
+
+// CheckNamedValue uses the NamedValueChecker of the statement, if it does
+// not implement it the one of the connection is used, like database/sql
+// does.
+func (s *tracedStmt) CheckNamedValue(v *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(v)
+	}
+
+	if s.conn != nil {
+		return s.conn.CheckNamedValue(v)
+	}
+
+	// database/sql applies its default conversion when ErrSkip is returned
+	return driver.ErrSkip
 }
@@ -0,0 +1,116 @@
+package sqltracing
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Defines the names of the tracing tags that are recorded for operations
+// with a timeout that was set via WithTimeout, WithQueryNameTimeout or
+// WithFingerprintTimeout.
+// DBTimeoutTagKey contains the timeout, DBTimeoutExceededTagKey is set to
+// true if the operation was canceled because the timeout expired.
+const (
+	DBTimeoutTagKey         = "db.timeout"
+	DBTimeoutExceededTagKey = "db.timeout.exceeded"
+)
+
+// timeouts are the default timeouts for operations.
+type timeouts struct {
+	ops          map[SQLOp]time.Duration
+	queryNames   map[string]time.Duration
+	fingerprints map[string]time.Duration
+}
+
+// opTimeout is a timeout that the Interceptor applied to an operation.
+type opTimeout struct {
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	span   Span
+}
+
+// applyTimeout returns a context with the deadline for the operation, if a
+// timeout is configured for it and the deadline of ctx is not earlier.
+// Timeouts are not applied to excluded operations.
+// Timeouts for queries have precedence over timeouts for operations, the
+// timeout for a fingerprint has precedence over the one for a query name.
+//
+// The returned opTimeout must be finished when the lifetime of the
+// operation ended, it is nil when no timeout was applied.
+func (t *Interceptor) applyTimeout(ctx context.Context, op SQLOp, query string, span Span) (context.Context, *opTimeout) {
+	if t.opIsExcluded(op) {
+		return ctx, nil
+	}
+
+	timeout, ok := t.timeout(ctx, op, query)
+	if !ok {
+		return ctx, nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && !ctxDeadline.After(deadline) {
+		return ctx, nil
+	}
+
+	span.SetTag(DBTimeoutTagKey, timeout.String())
+
+	timeoutCtx, cancel := context.WithDeadline(ctx, deadline)
+
+	return timeoutCtx, &opTimeout{
+		parent: ctx,
+		ctx:    timeoutCtx,
+		cancel: cancel,
+		span:   span,
+	}
+}
+
+func (t *Interceptor) timeout(ctx context.Context, op SQLOp, query string) (time.Duration, bool) {
+	if len(t.timeouts.fingerprints) > 0 && query != "" && op.hasQuery() {
+		fp := t.queryCache.get(query).fingerprintString()
+		if timeout, exist := t.timeouts.fingerprints[fp]; exist {
+			return timeout, true
+		}
+	}
+
+	if len(t.timeouts.queryNames) > 0 {
+		if name := queryNameFromContext(ctx); name != "" {
+			if timeout, exist := t.timeouts.queryNames[name]; exist {
+				return timeout, true
+			}
+		}
+	}
+
+	timeout, exist := t.timeouts.ops[op]
+	return timeout, exist
+}
+
+// finish tags the span if the timeout expired and releases the resources of
+// the context.
+// It is safe to be called on a nil opTimeout.
+func (o *opTimeout) finish() {
+	if o == nil {
+		return
+	}
+
+	if errors.Is(o.ctx.Err(), context.DeadlineExceeded) && o.parent.Err() == nil {
+		o.span.SetTag(DBTimeoutExceededTagKey, "true")
+	}
+
+	o.cancel()
+}
+
+// finishFunc returns a function that finishes the timeout and then calls
+// finishFn.
+// If o is nil, finishFn is returned.
+func (o *opTimeout) finishFunc(finishFn func(error)) func(error) {
+	if o == nil {
+		return finishFn
+	}
+
+	return func(err error) {
+		o.finish()
+		finishFn(err)
+	}
+}
@@ -21,3 +21,47 @@ type Span interface {
 	// Finish finishes the span.
 	Finish()
 }
+
+// SpanIDer is an optional interface that can be implemented by Spans to
+// expose the identifiers of the span and its trace.
+type SpanIDer interface {
+	// TraceID returns the ID of the trace the span belongs to.
+	// It returns an empty string if it is unknown.
+	TraceID() string
+	// SpanID returns the ID of the span.
+	// It returns an empty string if it is unknown.
+	SpanID() string
+}
+
+// SpanSampler is an optional interface that can be implemented by Spans to
+// report whether they are sampled.
+type SpanSampler interface {
+	// IsSampled returns true if the span is sampled.
+	// It returns false if it is unknown.
+	IsSampled() bool
+}
+
+// SpanEventer is an optional interface that can be implemented by Spans to
+// record timestamped events.
+type SpanEventer interface {
+	// AddEvent records an event called name with the passed attributes.
+	AddEvent(name string, attrs map[string]string)
+}
+
+// SpanKeyer is an optional interface that can be implemented by Tracers to
+// identify the span that is stored in a context.
+type SpanKeyer interface {
+	// SpanKey returns a comparable value that identifies the span in
+	// ctx. It returns nil if ctx does not contain a span.
+	SpanKey(ctx context.Context) interface{}
+}
+
+// spanIDs returns the trace and span ID of span, if it implements SpanIDer.
+func spanIDs(span Span) (traceID, spanID string) {
+	ider, ok := span.(SpanIDer)
+	if !ok {
+		return "", ""
+	}
+
+	return ider.TraceID(), ider.SpanID()
+}
@@ -0,0 +1,88 @@
+package sqltracing_test
+
+import (
+	"fmt"
+	"sync"
+
+	opentracing_go "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+// strictTracer is an opentracing tracer that records spans with a
+// MockTracer. Its spans panic when they are modified after they were
+// finished, the MockTracer accepts it.
+type strictTracer struct {
+	*mocktracer.MockTracer
+}
+
+type strictSpan struct {
+	opentracing_go.Span
+
+	mu       sync.Mutex
+	finished bool
+}
+
+func newStrictTracer(tracer *mocktracer.MockTracer) opentracing_go.Tracer {
+	return &strictTracer{MockTracer: tracer}
+}
+
+func (t *strictTracer) StartSpan(operationName string, opts ...opentracing_go.StartSpanOption) opentracing_go.Span {
+	return &strictSpan{Span: t.MockTracer.StartSpan(operationName, opts...)}
+}
+
+// checkNotFinished panics if the span was finished.
+func (s *strictSpan) checkNotFinished(method string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.finished {
+		panic(fmt.Sprintf("%s called on finished span %q", method, s.Span.(*mocktracer.MockSpan).OperationName))
+	}
+}
+
+func (s *strictSpan) Finish() {
+	s.markFinished()
+	s.Span.Finish()
+}
+
+func (s *strictSpan) FinishWithOptions(opts opentracing_go.FinishOptions) {
+	s.markFinished()
+	s.Span.FinishWithOptions(opts)
+}
+
+func (s *strictSpan) markFinished() {
+	s.checkNotFinished("Finish")
+
+	s.mu.Lock()
+	s.finished = true
+	s.mu.Unlock()
+}
+
+func (s *strictSpan) SetOperationName(operationName string) opentracing_go.Span {
+	s.checkNotFinished("SetOperationName")
+	s.Span.SetOperationName(operationName)
+	return s
+}
+
+func (s *strictSpan) SetTag(key string, value interface{}) opentracing_go.Span {
+	s.checkNotFinished("SetTag")
+	s.Span.SetTag(key, value)
+	return s
+}
+
+func (s *strictSpan) LogFields(fields ...log.Field) {
+	s.checkNotFinished("LogFields")
+	s.Span.LogFields(fields...)
+}
+
+func (s *strictSpan) LogKV(alternatingKeyValues ...interface{}) {
+	s.checkNotFinished("LogKV")
+	s.Span.LogKV(alternatingKeyValues...)
+}
+
+func (s *strictSpan) SetBaggageItem(restrictedKey, value string) opentracing_go.Span {
+	s.checkNotFinished("SetBaggageItem")
+	s.Span.SetBaggageItem(restrictedKey, value)
+	return s
+}
@@ -4,9 +4,11 @@ package opentracing
 
 import (
 	"context"
+	"sort"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
 	"github.com/simplesurance/sqltracing"
 )
 
@@ -21,6 +23,8 @@ type tracer struct {
 	defaultTags  opentracing.Tags
 	traceOrphans bool
 	getTracerFn  func() opentracing.Tracer
+	spanIDsFn    func(opentracing.SpanContext) (traceID, spanID string)
+	sampledFn    func(opentracing.SpanContext) bool
 }
 
 type span struct {
@@ -55,6 +59,30 @@ func WithTracer(fn func() opentracing.Tracer) Opt {
 	}
 }
 
+// WithSpanIDs is an option for NewTracer() to set a function that returns
+// the trace and span ID of a span context.
+// The opentracing API does not provide access to the IDs, the function must
+// convert the SpanContext to the type of the used tracer implementation.
+// When the option is set, the spans implement the sqltracing.SpanIDer
+// interface.
+func WithSpanIDs(fn func(opentracing.SpanContext) (traceID, spanID string)) Opt {
+	return func(t *tracer) {
+		t.spanIDsFn = fn
+	}
+}
+
+// WithSampled is an option for NewTracer() to set a function that returns
+// true if a span context is sampled.
+// Like for WithSpanIDs, the function must convert the SpanContext to the
+// type of the used tracer implementation.
+// When the option is set, the spans implement the sqltracing.SpanSampler
+// interface.
+func WithSampled(fn func(opentracing.SpanContext) bool) Opt {
+	return func(t *tracer) {
+		t.sampledFn = fn
+	}
+}
+
 // NewTracer returns a tracer that will create spans via opentracing-go.
 // When no options are specified, opentracing.GlobalTracer is used as default
 // Tracer, DefaultTracingTags are used as tags and TraceOrphans is enabled.
@@ -91,6 +119,15 @@ func (t *tracer) StartSpan(ctx context.Context, name string) (sqltracing.Span, c
 	return &span{span: otSpan, tracer: t}, opentracing.ContextWithSpan(ctx, otSpan)
 }
 
+// SpanKey returns the opentracing.Span in ctx.
+func (t *tracer) SpanKey(ctx context.Context) interface{} {
+	if parent := opentracing.SpanFromContext(ctx); parent != nil {
+		return parent
+	}
+
+	return nil
+}
+
 func (s *span) SetTag(k, v string) {
 	if s.span == nil {
 		return
@@ -124,3 +161,58 @@ func (s *span) Finish() {
 
 	s.span.Finish()
 }
+
+// AddEvent logs the event with its attributes as fields of the span.
+func (s *span) AddEvent(name string, attrs map[string]string) {
+	if s.span == nil {
+		return
+	}
+
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	fields := make([]log.Field, 0, len(attrs)+1)
+	fields = append(fields, log.String("event", name))
+	for _, k := range keys {
+		fields = append(fields, log.String(k, attrs[k]))
+	}
+
+	s.span.LogFields(fields...)
+}
+
+func (s *span) TraceID() string {
+	if s.span == nil || s.spanIDsFn == nil {
+		return ""
+	}
+
+	traceID, _ := s.spanIDsFn(s.span.Context())
+	return traceID
+}
+
+func (s *span) SpanID() string {
+	if s.span == nil || s.spanIDsFn == nil {
+		return ""
+	}
+
+	_, spanID := s.spanIDsFn(s.span.Context())
+	return spanID
+}
+
+func (s *span) IsSampled() bool {
+	if s.span == nil || s.sampledFn == nil {
+		return false
+	}
+
+	return s.sampledFn(s.span.Context())
+}
+
+var (
+	_ sqltracing.SpanIDer    = &span{}
+	_ sqltracing.SpanSampler = &span{}
+	_ sqltracing.SpanEventer = &span{}
+	_ sqltracing.SpanKeyer   = &tracer{}
+)
@@ -2,19 +2,82 @@ package sqltracing
 
 import (
 	"context"
+	"database/sql"
 	"database/sql/driver"
+	"strconv"
+	"sync/atomic"
+)
+
+// Defines the values of the DBTxOutcomeTagKey tag.
+const (
+	TxOutcomeCommit       = "commit"
+	TxOutcomeCommitFailed = "commit-failed"
+	TxOutcomeRollback     = "rollback"
 )
 
 type tracedTx struct {
 	driver.Tx
 	ctx                context.Context
+	span               Span
 	parentSpanFinishFn func(err error)
+	conn               *tracedConn
+	stats              *txStats
 }
 
-func newTracedTx(ctx context.Context, parentSpanFinishFn func(error), tx driver.Tx) *tracedTx {
+func newTracedTx(ctx context.Context, span Span, parentSpanFinishFn func(error), tx driver.Tx, conn *tracedConn) *tracedTx {
+	stats := txStats{}
+	if conn != nil {
+		conn.tx = &stats
+	}
+
 	return &tracedTx{
 		Tx:                 tx,
 		ctx:                ctx,
+		span:               span,
 		parentSpanFinishFn: parentSpanFinishFn,
+		conn:               conn,
+		stats:              &stats,
+	}
+}
+
+// finish records the outcome and the number of statements of the
+// transaction on the span of the BeginTx operation and finishes it.
+func (t *tracedTx) finish(outcome string) {
+	if t.conn != nil && t.conn.tx == t.stats {
+		t.conn.tx = nil
+	}
+
+	t.span.SetTags(map[string]string{
+		DBTxOutcomeTagKey:    outcome,
+		DBTxStatementsTagKey: strconv.FormatInt(atomic.LoadInt64(&t.stats.statements), 10),
+	})
+
+	// nil instead of err is passed because it finishes the operation that
+	// created the Tx, which succeeded
+	t.parentSpanFinishFn(nil)
+}
+
+// isolationLevelName returns the name of the isolation level, as it is
+// recorded in the DBTxIsolationTagKey tag.
+func isolationLevelName(lvl driver.IsolationLevel) string {
+	switch sql.IsolationLevel(lvl) {
+	case sql.LevelDefault:
+		return "default"
+	case sql.LevelReadUncommitted:
+		return "read_uncommitted"
+	case sql.LevelReadCommitted:
+		return "read_committed"
+	case sql.LevelWriteCommitted:
+		return "write_committed"
+	case sql.LevelRepeatableRead:
+		return "repeatable_read"
+	case sql.LevelSnapshot:
+		return "snapshot"
+	case sql.LevelSerializable:
+		return "serializable"
+	case sql.LevelLinearizable:
+		return "linearizable"
+	default:
+		return "isolation_level_" + strconv.Itoa(int(lvl))
 	}
 }
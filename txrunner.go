@@ -0,0 +1,254 @@
+package sqltracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// Defines the names of the spans that are recorded by RunInTx.
+const (
+	TxSpanName        = "sql-tx"
+	TxAttemptSpanName = "sql-tx-attempt"
+)
+
+// Defines the names of the tracing tags that are recorded by RunInTx.
+// DBTxAttemptsTagKey is set on the TxSpanName span, the other tags on the
+// TxAttemptSpanName spans.
+const (
+	DBTxAttemptsTagKey      = "db.tx.attempts"
+	DBTxAttemptTagKey       = "db.tx.attempt"
+	DBTxFailureReasonTagKey = "db.tx.failure_reason"
+)
+
+// Defines the SQLSTATE error codes of retryable transaction failures.
+const (
+	SQLStateSerializationFailure = "40001"
+	SQLStateDeadlockDetected     = "40P01"
+)
+
+// DefaultTxMaxAttempts is the default maximum number of attempts of
+// RunInTx.
+const DefaultTxMaxAttempts = 5
+
+// RunInTxOpts are options for RunInTx.
+// The zero value is valid and uses the defaults.
+type RunInTxOpts struct {
+	// TxOptions are passed to sql.DB.BeginTx.
+	TxOptions *sql.TxOptions
+	// Tracer is used to record spans for the transaction and its
+	// attempts. If it is nil, no spans are recorded.
+	// RunInTx does not use the Tracer of the Interceptor of db, usually
+	// the Tracer that was passed to WrapDriver is set.
+	Tracer Tracer
+	// MaxAttempts is the maximum number of attempts. The default is
+	// DefaultTxMaxAttempts.
+	MaxAttempts int
+	// Backoff returns the duration to wait after the failed attempt with
+	// the passed number, starting at 1. The default is DefaultTxBackoff.
+	Backoff func(attempt int) time.Duration
+	// IsRetryable returns true if the transaction can be retried after
+	// it failed with err. The default is IsRetryableTxError.
+	IsRetryable func(err error) bool
+}
+
+// sqlStater is implemented by the errors of Postgres drivers like pgx and
+// lib/pq.
+type sqlStater interface {
+	SQLState() string
+}
+
+// IsRetryableTxError returns true if err or an error in its chain has an
+// SQLState() string method that returns SQLStateSerializationFailure or
+// SQLStateDeadlockDetected.
+func IsRetryableTxError(err error) bool {
+	switch sqlState(err) {
+	case SQLStateSerializationFailure, SQLStateDeadlockDetected:
+		return true
+	default:
+		return false
+	}
+}
+
+// DefaultTxBackoff returns an exponentially increasing duration with
+// jitter, that starts at 10ms and is at most 1s.
+func DefaultTxBackoff(attempt int) time.Duration {
+	const (
+		minBackoff = 10 * time.Millisecond
+		maxBackoff = time.Second
+	)
+
+	backoff := maxBackoff
+	if attempt < 8 {
+		backoff = minBackoff << uint(attempt-1)
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// RunInTx runs fn in a transaction on db and commits it, if fn returns
+// nil. Otherwise the transaction is rolled back.
+// If an attempt fails with an error that is retryable according to
+// opts.IsRetryable, the transaction is retried after a backoff, until the
+// maximum number of attempts is reached or ctx is done.
+// The error of the last attempt is returned.
+//
+// fn must not commit or roll back the transaction. It is called with a
+// context that contains the span of the attempt, it should be used for the
+// operations of the transaction.
+//
+// If opts.Tracer is set, a TxSpanName span is recorded with a
+// TxAttemptSpanName child span per attempt.
+// Attempts are tagged with their number and failed attempts with the
+// reason of the failure. It is the name of the SQLSTATE for serialization
+// failures and deadlocks, the SQLSTATE code for other errors with a
+// SQLSTATE, "panic" if fn panicked and "error" otherwise.
+// When fn panics, the transaction is rolled back, the spans are finished
+// with an error and the panic is propagated.
+// opts can be nil.
+func RunInTx(ctx context.Context, db *sql.DB, opts *RunInTxOpts, fn func(ctx context.Context, tx *sql.Tx) error) (err error) {
+	var o RunInTxOpts
+	if opts != nil {
+		o = *opts
+	}
+
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = DefaultTxMaxAttempts
+	}
+
+	if o.Backoff == nil {
+		o.Backoff = DefaultTxBackoff
+	}
+
+	if o.IsRetryable == nil {
+		o.IsRetryable = IsRetryableTxError
+	}
+
+	span, ctx := o.startSpan(ctx, TxSpanName)
+	attempt := 0
+
+	defer func() {
+		span.SetTag(DBTxAttemptsTagKey, strconv.Itoa(attempt))
+
+		if p := recover(); p != nil {
+			span.SetError(panicError(p))
+			span.Finish()
+			panic(p)
+		}
+
+		if err != nil {
+			span.SetError(err)
+		}
+
+		span.Finish()
+	}()
+
+	for {
+		attempt++
+
+		err = o.runAttempt(ctx, db, attempt, fn)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= o.MaxAttempts || !o.IsRetryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(o.Backoff(attempt))
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+func (o *RunInTxOpts) startSpan(ctx context.Context, spanName string) (Span, context.Context) {
+	if o.Tracer == nil {
+		return noopSpan{}, ctx
+	}
+
+	return o.Tracer.StartSpan(ctx, spanName)
+}
+
+func (o *RunInTxOpts) runAttempt(ctx context.Context, db *sql.DB, attempt int, fn func(ctx context.Context, tx *sql.Tx) error) (err error) {
+	span, ctx := o.startSpan(ctx, TxAttemptSpanName)
+	span.SetTag(DBTxAttemptTagKey, strconv.Itoa(attempt))
+
+	defer func() {
+		if p := recover(); p != nil {
+			span.SetTag(DBTxFailureReasonTagKey, "panic")
+			span.SetError(panicError(p))
+			span.Finish()
+			panic(p)
+		}
+
+		if err != nil {
+			span.SetTag(DBTxFailureReasonTagKey, txFailureReason(err))
+			span.SetError(err)
+		}
+
+		span.Finish()
+	}()
+
+	tx, err := db.BeginTx(ctx, o.TxOptions)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(ctx, tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// panicError returns the error that is recorded on the spans of a
+// transaction when fn panicked with p.
+func panicError(p interface{}) error {
+	return fmt.Errorf("panic: %v", p)
+}
+
+// txFailureReason returns the value of the DBTxFailureReasonTagKey tag for
+// err.
+func txFailureReason(err error) string {
+	switch code := sqlState(err); code {
+	case SQLStateSerializationFailure:
+		return "serialization_failure"
+	case SQLStateDeadlockDetected:
+		return "deadlock_detected"
+	case "":
+		return "error"
+	default:
+		return code
+	}
+}
+
+// sqlState returns the SQLSTATE code of err, if err or an error in its
+// chain implements sqlStater. Otherwise an empty string is returned.
+func sqlState(err error) string {
+	var stater sqlStater
+	if errors.As(err, &stater) {
+		return stater.SQLState()
+	}
+
+	return ""
+}